@@ -2,36 +2,85 @@ package cmd
 
 import (
 	"fmt"
+	"os"
 
+	"github.com/git-depend/git-depend/pkg/git"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
 
+var addProject project
+
 var addCmd = &cobra.Command{
-	Use:   "add",
+	Use:   "add <project-url>...",
 	Short: "Add a project to your dependency.",
-	Long:  `Add a project to your dependency.`,
-	Args:  cobra.MinimumNArgs(1),
+	Long: `Add a project to your dependency.
+A project which is already there is updated with the flags which are given.`,
+	Args: cobra.MinimumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		viper.Unmarshal(&cfg)
+		if addProject.Name != "" && len(args) > 1 {
+			fmt.Println("--name can only be given for one project.")
+			os.Exit(1)
+		}
+		strategy, _ := cmd.Flags().GetString("strategy")
+		addProject.Strategy = git.MergeStrategy(strategy)
+		if err := addProject.Strategy.Valid(); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
 		for _, arg := range args {
-			cfg.Projects = appendIfMissing(cfg.Projects, arg)
+			p := findProjectByURL(arg)
+			if p == nil {
+				cfg.Projects = append(cfg.Projects, project{URL: arg})
+				p = &cfg.Projects[len(cfg.Projects)-1]
+			}
+			updateProject(cmd, p)
+		}
+		setProjects(cfg.Projects)
+		if err := writeConfig(); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
 		}
-		viper.Set("Projects", cfg.Projects)
-		viper.WriteConfig()
 		fmt.Println(cfg.String())
 	},
 }
 
 func init() {
+	addCmd.Flags().StringVar(&addProject.Name, "name", "", "the name of the project (defaults to the last element of the URL)")
+	addCmd.Flags().StringVar(&addProject.Branch, "branch", "", "the branch to merge into (defaults to master)")
+	addCmd.Flags().StringVar(&addProject.Remote, "remote", "", "the name of the fork to merge branches from")
+	addCmd.Flags().String("strategy", "", "rebase-ff, merge-commit, squash or ff-only-no-marker (defaults to rebase-ff)")
+	addCmd.Flags().StringSliceVar(&addProject.Deps, "deps", nil, "the names of the projects which this one depends on")
 	rootCmd.AddCommand(addCmd)
 }
 
-func appendIfMissing(slice []string, i string) []string {
-	for _, ele := range slice {
-		if ele == i {
-			return slice
+// updateProject with the flags which were given.
+func updateProject(cmd *cobra.Command, p *project) {
+	flags := cmd.Flags()
+	if flags.Changed("name") {
+		p.Name = addProject.Name
+	}
+	if flags.Changed("branch") {
+		p.Branch = addProject.Branch
+	}
+	if flags.Changed("remote") {
+		p.Remote = addProject.Remote
+	}
+	if flags.Changed("strategy") {
+		p.Strategy = addProject.Strategy
+	}
+	if flags.Changed("deps") {
+		p.Deps = addProject.Deps
+	}
+}
+
+// findProjectByURL returns the configured project with the URL, or nil.
+func findProjectByURL(url string) *project {
+	for i := range cfg.Projects {
+		if cfg.Projects[i].URL == url {
+			return &cfg.Projects[i]
 		}
 	}
-	return append(slice, i)
+	return nil
 }
@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var repair bool
+
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Manage the cache.",
+	Long:  `Manage the repositories which are cloned into the cache.`,
+}
+
+var cacheVerifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Verify the integrity of the cache.",
+	Long: `Runs git fsck on every cached repository and checks that origin still
+matches the URL the directory was named after. Also finds clones which were
+never completed, and repositories with detached or dirty working trees.
+With --repair, broken repositories are cloned again.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		viper.Unmarshal(&cfg)
+		if repair {
+			requireOnline(cmd)
+		}
+		cache := newCache()
+		results, err := cache.Verify(cmd.Context(), repair)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		failed := false
+		for _, result := range results {
+			status := "broken"
+			if result.Repaired {
+				status = "repaired"
+			} else {
+				failed = true
+			}
+			fmt.Printf("%s: %s %v\n", status, result.Directory, result.Problems)
+			if result.URL != "" {
+				fmt.Println("\tURL:", result.URL)
+			}
+			if result.Err != nil {
+				fmt.Println("\tError:", result.Err)
+			}
+		}
+		if failed {
+			os.Exit(1)
+		}
+		fmt.Println("Cache verified:", getCacheDir())
+	},
+}
+
+func init() {
+	cacheVerifyCmd.Flags().BoolVar(&repair, "repair", false, "Repair the problems which can be safely fixed")
+	cacheCmd.AddCommand(cacheVerifyCmd)
+	rootCmd.AddCommand(cacheCmd)
+}
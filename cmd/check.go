@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var checkInto string
+var checkFrom string
+
+var checkCmd = &cobra.Command{
+	Use:   "check <branch>",
+	Short: "Check that a branch merges cleanly.",
+	Long: `Check whether the branch merges cleanly into the target branch of every
+project, and list the files which conflict. Nothing is locked or checked out,
+so this never blocks other merges. merge runs the same check before taking
+any locks.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		viper.Unmarshal(&cfg)
+		cache := newCache()
+		graph := newGraph(cache)
+		requests := graph.NewRequests()
+		for _, name := range graph.Names() {
+			if err := requests.AddRequest(name, args[0], targetBranch(name, checkInto), cfg.Author, cfg.Email); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		}
+
+		setRemotes(requests, checkFrom)
+
+		failed := false
+		for _, result := range requests.Check(cmd.Context()) {
+			switch {
+			case result.Err != nil:
+				fmt.Printf("error: %s\n\tError: %v\n", result.Name, result.Err)
+			case len(result.Conflicts) != 0:
+				fmt.Printf("conflict: %s\n", result.Name)
+				for _, file := range result.Conflicts {
+					fmt.Println("\t" + file)
+				}
+			default:
+				fmt.Printf("clean: %s\n", result.Name)
+				continue
+			}
+			failed = true
+		}
+		if failed {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	checkCmd.Flags().StringVar(&checkFrom, "from", "", "the name of the remotes to take the branch from, e.g. the owner of the forks (defaults to each project's remote)")
+	checkCmd.Flags().StringVar(&checkInto, "into", "", "the branch to merge into (defaults to each project's branch)")
+	rootCmd.AddCommand(checkCmd)
+}
@@ -2,21 +2,60 @@ package cmd
 
 import (
 	"fmt"
+	"os"
 
+	"github.com/git-depend/git-depend/pkg/git"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
 
+var commitBranch string
+
 var commitCmd = &cobra.Command{
 	Use:   "commit",
 	Short: "Commits a dependency structure.",
-	Long:  `Creates a tree of dependencies and attempts to commit them.`,
+	Long: `Creates a tree of dependencies from the configured projects and writes each
+project's dependencies as a note on the tip of its branch. Every repository is
+locked while its note is written. The note of every project is attempted, and
+it exits with an error if any of them couldn't be written.
+With --dry-run, the notes are written in a scratch cache and the pushes which
+would have been made are printed.`,
+	Args: cobra.NoArgs,
 	Run: func(cmd *cobra.Command, args []string) {
 		viper.Unmarshal(&cfg)
-		fmt.Printf("Command currently not supported.")
+		requireOnline(cmd)
+		cache, cleanup := newCommandCache()
+		defer cleanup()
+
+		graph := newGraph(cache)
+		branches := make(map[string]string)
+		for _, name := range graph.Names() {
+			branches[name] = targetBranch(name, commitBranch)
+		}
+
+		owner := cfg.Author
+		if cfg.Email != "" {
+			owner += " <" + cfg.Email + ">"
+		}
+		failed := false
+		for _, result := range graph.CommitDependencyNotes(cmd.Context(), git.Transaction(cmd.Context()), owner, branches) {
+			if result.Err != nil {
+				fmt.Printf("error: %s\n\tError: %v\n", result.Name, result.Err)
+				failed = true
+				continue
+			}
+			fmt.Printf("written: %s on %s\n", result.Name, result.Branch)
+		}
+		printMutations(cache)
+		if failed {
+			cleanup()
+			os.Exit(1)
+		}
 	},
 }
 
 func init() {
+	commitCmd.Flags().StringVar(&commitBranch, "branch", "", "the branch to write the dependencies to (defaults to each project's branch)")
+	addDryRunFlag(commitCmd)
 	rootCmd.AddCommand(commitCmd)
 }
@@ -2,29 +2,52 @@ package cmd
 
 import (
 	"fmt"
+	"os"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
 
+var showOrigin bool
+
 var configCmd = &cobra.Command{
 	Use:   "config",
 	Short: "Write to the configuration file.",
-	Long:  `Add your name and email address to a configuration file.`,
+	Long: `Add your name and email address to a configuration file.
+The config is read from /etc/git-depend.toml, then $HOME/.git-depend.toml
+(or --config), then the nearest .git-depend.toml in the git repository,
+then GIT_DEPEND_* environment variables, e.g. GIT_DEPEND_AUTHOR, then flags.
+Each one overrides the ones before it. A value is written to the file which
+it came from, or to the user's file.
+With --show-origin, every value is printed with the layer which set it.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		viper.Unmarshal(&cfg)
+		if showOrigin {
+			printOrigins(os.Stdout)
+			return
+		}
+		for _, key := range []string{"author", "email"} {
+			if cmd.Flags().Changed(key) {
+				setConfig(key, viper.GetString(key))
+			}
+		}
 		fmt.Println("Writing config...")
-		viper.WriteConfig()
+		if err := writeConfig(); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
 		fmt.Println(cfg.String())
 	},
 }
 
 func init() {
 	configCmd.Flags().StringVar(&cfg.Author, "author", "", "Author name")
-	viper.BindPFlag("author", configCmd.Flags().Lookup("author"))
+	bindFlag("author", configCmd.Flags().Lookup("author"))
 
 	configCmd.Flags().StringVar(&cfg.Email, "email", "", "Email address")
-	viper.BindPFlag("email", configCmd.Flags().Lookup("email"))
+	bindFlag("email", configCmd.Flags().Lookup("email"))
+
+	configCmd.Flags().BoolVar(&showOrigin, "show-origin", false, "show the file, environment variable or flag which set each value")
 
 	rootCmd.AddCommand(configCmd)
 }
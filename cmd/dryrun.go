@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/git-depend/git-depend/pkg/git"
+	"github.com/spf13/cobra"
+)
+
+var dryRun bool
+
+// addDryRunFlag to a command which changes the remotes.
+func addDryRunFlag(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "print the changes to the remotes without making them")
+}
+
+// newCommandCache returns the cache, or a scratch cache for a dry run.
+// The returned function removes the scratch cache.
+func newCommandCache() (*git.Cache, func()) {
+	if !dryRun {
+		return newCache(), func() {}
+	}
+	dir, err := ioutil.TempDir("", "git-depend-dry-run")
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	return newCacheAt(dir, true), func() { os.RemoveAll(dir) }
+}
+
+// printMutations lists what a dry run would have pushed.
+func printMutations(cache *git.Cache) {
+	if !cache.DryRun() {
+		return
+	}
+	mutations := cache.Mutations()
+	fmt.Printf("Dry run, %d change(s) would be pushed:\n", len(mutations))
+	for _, m := range mutations {
+		old := m.Old
+		if old == "" {
+			old = "(new)"
+		}
+		fmt.Printf("  %s %s %s -> %s\n", m.URL, m.Ref, old, m.New)
+	}
+}
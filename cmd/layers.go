@@ -0,0 +1,237 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	homedir "github.com/mitchellh/go-homedir"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+const (
+	// systemConfigFile is read first, and can be moved with GIT_DEPEND_CONFIG_SYSTEM.
+	systemConfigFile = "/etc/git-depend.toml"
+	// configName is the user's file in $HOME, and the repository's file,
+	// which is searched for from the working directory up to the top of the git repository.
+	configName = ".git-depend.toml"
+	// envPrefix of the environment variables which set the config, e.g. GIT_DEPEND_AUTHOR.
+	envPrefix = "GIT_DEPEND"
+)
+
+// layer is a config file.
+// Later layers override earlier ones, then the environment, then the flags.
+type layer struct {
+	file  string
+	viper *viper.Viper
+	// dirty is set when the file needs writing.
+	dirty bool
+}
+
+var layers []*layer
+
+// userLayer is written unless the key came from another file.
+var userLayer *layer
+
+// envKeys maps the keys to the environment variables which set them.
+var envKeys = make(map[string]string)
+
+// flagKeys maps the keys to the flags which set them.
+var flagKeys = make(map[string]*pflag.Flag)
+
+// loadLayers reads the system, user and repository config files and merges them.
+// The user file is created if it doesn't exist, unless it was given with --config.
+func loadLayers() error {
+	user := cfgFile
+	if user == "" {
+		home, err := homedir.Dir()
+		if err != nil {
+			return err
+		}
+		user = path.Join(home, configName)
+		if _, err := os.Stat(user); os.IsNotExist(err) {
+			if err := ioutil.WriteFile(user, nil, 0644); err != nil {
+				return err
+			}
+		}
+	}
+	system := os.Getenv(envPrefix + "_CONFIG_SYSTEM")
+	if system == "" {
+		system = systemConfigFile
+	}
+
+	settings := make(map[string]interface{})
+	for _, file := range []string{system, user, findRepoConfig()} {
+		if file == "" {
+			continue
+		}
+		if _, err := os.Stat(file); os.IsNotExist(err) && file != user {
+			continue
+		}
+		if contains(configFiles(), file) {
+			// The user's file is also the file of the repository in $HOME.
+			continue
+		}
+		l := &layer{file: file, viper: viper.New()}
+		l.viper.SetConfigFile(file)
+		l.viper.SetConfigType("toml")
+		if err := l.viper.ReadInConfig(); err != nil {
+			return err
+		}
+		migrateConfig(l)
+		mergeSettings(settings, l.viper.AllSettings())
+		layers = append(layers, l)
+		if file == user {
+			userLayer = l
+		}
+	}
+	if err := viper.MergeConfigMap(settings); err != nil {
+		return err
+	}
+	bindEnv(reflect.TypeOf(config{}), "")
+	return nil
+}
+
+// mergeSettings of a layer into the settings of the layers before it.
+// Tables are merged key by key, anything else such as Projects is replaced.
+func mergeSettings(settings map[string]interface{}, layer map[string]interface{}) {
+	for key, value := range layer {
+		table, ok := value.(map[string]interface{})
+		existing, isTable := settings[key].(map[string]interface{})
+		if ok && isTable {
+			mergeSettings(existing, table)
+			continue
+		}
+		settings[key] = value
+	}
+}
+
+// findRepoConfig returns the nearest configName between the working directory
+// and the top of its git repository, or nothing outside a git repository.
+func findRepoConfig() string {
+	dir, err := os.Getwd()
+	if err != nil {
+		return ""
+	}
+	found := ""
+	for {
+		file := filepath.Join(dir, configName)
+		if _, err := os.Stat(file); err == nil && found == "" {
+			found = file
+		}
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			return found
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// bindEnv lets GIT_DEPEND_<KEY> set every field of the config, e.g. GIT_DEPEND_MAXWORKERS,
+// and GIT_DEPEND_SIGNING_KEY for the fields of a table.
+// Lists and maps, such as Projects, can only be set in the files.
+func bindEnv(t reflect.Type, prefix string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		key := strings.ToLower(prefix + field.Name)
+		switch field.Type.Kind() {
+		case reflect.Slice, reflect.Map:
+			continue
+		case reflect.Struct:
+			bindEnv(field.Type, key+".")
+			continue
+		}
+		env := envPrefix + "_" + strings.ToUpper(strings.Replace(key, ".", "_", -1))
+		viper.BindEnv(key, env)
+		envKeys[key] = env
+	}
+}
+
+// bindFlag makes the flag override the key, when it is given.
+func bindFlag(key string, flag *pflag.Flag) {
+	viper.BindPFlag(key, flag)
+	flagKeys[key] = flag
+}
+
+// origin of the value of the key, in the form of git config --show-origin.
+func origin(key string) string {
+	if flag, ok := flagKeys[key]; ok && flag.Changed {
+		return "flag:--" + flag.Name
+	}
+	if env, ok := envKeys[key]; ok {
+		if _, ok := os.LookupEnv(env); ok {
+			return "env:" + env
+		}
+	}
+	for i := len(layers) - 1; i >= 0; i-- {
+		if layers[i].viper.IsSet(key) {
+			return "file:" + layers[i].file
+		}
+	}
+	return "default"
+}
+
+// printOrigins of every key which has a value, sorted by key.
+func printOrigins(w io.Writer) {
+	keys := viper.AllKeys()
+	sort.Strings(keys)
+	for _, key := range keys {
+		if viper.Get(key) == nil {
+			continue
+		}
+		value, err := json.Marshal(viper.Get(key))
+		if err != nil {
+			value = []byte(fmt.Sprint(viper.Get(key)))
+		}
+		fmt.Fprintf(w, "%s\t%s=%s\n", origin(key), key, value)
+	}
+}
+
+// setConfig in the file which the key came from, or the user's file.
+// Nothing is written until writeConfig.
+func setConfig(key string, value interface{}) {
+	l := userLayer
+	for i := len(layers) - 1; i >= 0; i-- {
+		if layers[i].viper.IsSet(key) {
+			l = layers[i]
+			break
+		}
+	}
+	l.viper.Set(key, value)
+	l.dirty = true
+	viper.Set(key, value)
+}
+
+// writeConfig writes the files which were changed by setConfig.
+func writeConfig() error {
+	for _, l := range layers {
+		if !l.dirty {
+			continue
+		}
+		if err := l.viper.WriteConfig(); err != nil {
+			return err
+		}
+		l.dirty = false
+	}
+	return nil
+}
+
+// configFiles which were read, from the lowest precedence to the highest.
+func configFiles() []string {
+	files := make([]string, len(layers))
+	for i, l := range layers {
+		files[i] = l.file
+	}
+	return files
+}
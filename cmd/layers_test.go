@@ -0,0 +1,176 @@
+package cmd
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+const systemConfig = `Author = "system"
+Email = "system@email.com"
+Projects = [{URL = "https://host/system.git"}]
+
+[Signing]
+  Key = "system-key"
+`
+
+const userConfig = `Author = "user"
+MaxWorkers = 2
+
+[Signing]
+  Format = "ssh"
+`
+
+const repoConfig = `Author = "repo"
+Projects = [{URL = "https://host/repo.git"}]
+`
+
+func TestLayersPrecedence(t *testing.T) {
+	files := createLayers(t)
+
+	if got := viper.GetString("author"); got != "repo" {
+		t.Fatalf("The repository's file should override the others: %q", got)
+	}
+	if got := viper.GetString("email"); got != "system@email.com" {
+		t.Fatalf("Expected the email of the system's file: %q", got)
+	}
+	if got := viper.GetInt("maxworkers"); got != 2 {
+		t.Fatalf("Expected the workers of the user's file: %d", got)
+	}
+	// Tables are merged key by key.
+	if got := viper.GetString("signing.key"); got != "system-key" {
+		t.Fatalf("Expected the key of the system's file: %q", got)
+	}
+	if got := viper.GetString("signing.format"); got != "ssh" {
+		t.Fatalf("Expected the format of the user's file: %q", got)
+	}
+	if got := origin("author"); got != "file:"+files[2] {
+		t.Fatalf("Unexpected origin of the author: %q", got)
+	}
+	if got := origin("maxworkers"); got != "file:"+files[1] {
+		t.Fatalf("Unexpected origin of the workers: %q", got)
+	}
+}
+
+func TestLayersReplaceProjects(t *testing.T) {
+	createLayers(t)
+	viper.Unmarshal(&cfg)
+	if len(cfg.Projects) != 1 || cfg.Projects[0].URL != "https://host/repo.git" {
+		t.Fatalf("The projects of the repository's file should replace the others: %+v", cfg.Projects)
+	}
+}
+
+func TestLayersOrigin(t *testing.T) {
+	createLayers(t)
+	os.Setenv("GIT_DEPEND_EMAIL", "env@email.com")
+	defer os.Unsetenv("GIT_DEPEND_EMAIL")
+	flag := configCmd.Flags().Lookup("author")
+	if err := flag.Value.Set("flag"); err != nil {
+		t.Fatal(err)
+	}
+	flag.Changed = true
+	defer func() {
+		flag.Value.Set("")
+		flag.Changed = false
+	}()
+
+	if got := viper.GetString("author"); got != "flag" {
+		t.Fatalf("The flag should override the files: %q", got)
+	}
+	if got := viper.GetString("email"); got != "env@email.com" {
+		t.Fatalf("The environment should override the files: %q", got)
+	}
+	var out bytes.Buffer
+	printOrigins(&out)
+	for _, line := range []string{
+		"flag:--author\tauthor=\"flag\"",
+		"env:GIT_DEPEND_EMAIL\temail=\"env@email.com\"",
+		"default\toffline=false",
+	} {
+		if !strings.Contains(out.String(), line+"\n") {
+			t.Fatalf("Expected %q in the origins:\n%s", line, out.String())
+		}
+	}
+}
+
+func TestAddWritesProjectsLayer(t *testing.T) {
+	files := createLayers(t)
+	user, err := ioutil.ReadFile(files[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addCmd.Run(addCmd, []string{"https://host/new.git"})
+
+	l := readLayer(t, files[2])
+	var projects []project
+	if err := l.viper.UnmarshalKey("Projects", &projects); err != nil {
+		t.Fatal(err)
+	}
+	if len(projects) != 2 || projects[1].URL != "https://host/new.git" {
+		t.Fatalf("The project should be added to the repository's file: %+v", projects)
+	}
+	if after, err := ioutil.ReadFile(files[1]); err != nil || string(after) != string(user) {
+		t.Fatalf("The user's file should not change: %s %v", after, err)
+	}
+}
+
+// createLayers writes the system, user and repository files, and loads them
+// from a working directory inside the repository.
+// Returns the files, and puts the config back afterwards.
+func createLayers(t *testing.T) []string {
+	dir := t.TempDir()
+	repo := path.Join(dir, "repo")
+	if err := os.MkdirAll(path.Join(repo, ".git"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	work := path.Join(repo, "src")
+	if err := os.Mkdir(work, 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := []string{path.Join(dir, "system.toml"), path.Join(dir, "user.toml"), path.Join(repo, configName)}
+	for i, content := range []string{systemConfig, userConfig, repoConfig} {
+		if err := ioutil.WriteFile(files[i], []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(work); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv(envPrefix+"_CONFIG_SYSTEM", files[0])
+	cfgFile = files[1]
+	t.Cleanup(func() {
+		os.Chdir(wd)
+		os.Unsetenv(envPrefix + "_CONFIG_SYSTEM")
+		cfgFile = ""
+		resetLayers()
+	})
+
+	resetLayers()
+	if err := loadLayers(); err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+// resetLayers forgets the config which was loaded, but keeps the flags which were bound in init.
+func resetLayers() {
+	layers = nil
+	userLayer = nil
+	envKeys = make(map[string]string)
+	cfg = config{}
+	viper.Reset()
+	for key, flag := range flagKeys {
+		viper.BindPFlag(key, flag)
+	}
+}
@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/git-depend/git-depend/pkg/git"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var lsJSON bool
+
+var lsCmd = &cobra.Command{
+	Use:   "ls",
+	Short: "List the projects.",
+	Long: `List the configured projects, with the defaults filled in.
+With --json, the projects are printed as a JSON array.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		viper.Unmarshal(&cfg)
+		projects := make([]project, len(cfg.Projects))
+		for i, p := range cfg.Projects {
+			projects[i] = p
+			projects[i].Name = p.name()
+			projects[i].Branch = p.branch()
+			if p.Strategy == "" {
+				projects[i].Strategy = git.DefaultMergeStrategy
+			}
+		}
+
+		if lsJSON {
+			out, err := json.MarshalIndent(projects, "", "\t")
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			fmt.Println(string(out))
+			return
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tURL\tBRANCH\tREMOTE\tSTRATEGY\tDEPS")
+		for _, p := range projects {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", p.Name, p.URL, p.Branch, p.Remote, p.Strategy, strings.Join(p.Deps, ","))
+		}
+		w.Flush()
+	},
+}
+
+func init() {
+	lsCmd.Flags().BoolVar(&lsJSON, "json", false, "print the projects as JSON")
+	rootCmd.AddCommand(lsCmd)
+}
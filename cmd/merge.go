@@ -2,21 +2,63 @@ package cmd
 
 import (
 	"fmt"
+	"os"
 
+	"github.com/git-depend/git-depend/pkg/git"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
 
+var mergeInto string
+var mergeFrom string
+var mergeStrategyFlag string
+
 var mergeCmd = &cobra.Command{
-	Use:   "merge",
+	Use:   "merge <branch>",
 	Short: "Merge project branches.",
-	Long:  `Not currently working. Merge branches accross multiple repositories.`,
+	Long: `Merge a branch into the target branch of every project.
+All the projects are locked, and the locks are released if any merge fails.
+The strategy of each project decides how the branch lands, see --strategy.
+With --dry-run, the merges happen in a scratch cache and the pushes which
+would have been made are printed.`,
+	Args: cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		viper.Unmarshal(&cfg)
-		fmt.Println("Currently not functioning.")
+		requireOnline(cmd)
+		cache, cleanup := newCommandCache()
+		defer cleanup()
+
+		graph := newGraph(cache)
+		requests := graph.NewRequests()
+		for _, name := range graph.Names() {
+			if err := requests.AddRequest(name, args[0], targetBranch(name, mergeInto), cfg.Author, cfg.Email); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			if mergeStrategyFlag == "" {
+				continue
+			}
+			if err := requests.SetStrategy(name, git.MergeStrategy(mergeStrategyFlag)); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		}
+		setRemotes(requests, mergeFrom)
+		fmt.Println("Transaction:", git.Transaction(cmd.Context()))
+		err := requests.Merge(cmd.Context())
+		printMutations(cache)
+		if err != nil {
+			fmt.Println(err)
+			cleanup()
+			os.Exit(1)
+		}
 	},
 }
 
 func init() {
+	mergeCmd.Flags().StringVar(&mergeFrom, "from", "", "the name of the remotes to take the branch from, e.g. the owner of the forks (defaults to each project's remote)")
+	mergeCmd.Flags().StringVar(&mergeInto, "into", "", "the branch to merge into (defaults to each project's branch)")
+	mergeCmd.Flags().StringVar(&mergeStrategyFlag, "strategy", "", "rebase-ff, merge-commit, squash or ff-only-no-marker for every project (defaults to each project's strategy)")
+	addDryRunFlag(mergeCmd)
 	rootCmd.AddCommand(mergeCmd)
 }
@@ -0,0 +1,179 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/git-depend/git-depend/pkg/git"
+	"github.com/spf13/viper"
+)
+
+// defaultBranch is merged into when neither the project nor --into says otherwise.
+const defaultBranch = "master"
+
+// project is a table in the config.
+type project struct {
+	// Name in the graph, defaults to the last element of the URL.
+	Name string
+	URL  string
+	// Branch is merged into, defaults to master.
+	Branch string
+	// Remote is the name of the fork in Remotes which branches are merged from, unless --from is given.
+	Remote string
+	// Strategy defaults to rebase-ff.
+	Strategy git.MergeStrategy
+	// Deps are the names of the projects which this one depends on.
+	Deps []string
+}
+
+// name of the project in the graph.
+func (p *project) name() string {
+	if p.Name != "" {
+		return p.Name
+	}
+	return projectName(p.URL)
+}
+
+// branch which is merged into.
+func (p *project) branch() string {
+	if p.Branch != "" {
+		return p.Branch
+	}
+	return defaultBranch
+}
+
+// projectName is the last element of the URL.
+func projectName(url string) string {
+	return strings.TrimSuffix(path.Base(url), ".git")
+}
+
+// findProject by its name in the graph.
+func findProject(name string) *project {
+	for i := range cfg.Projects {
+		if cfg.Projects[i].name() == name {
+			return &cfg.Projects[i]
+		}
+	}
+	return nil
+}
+
+// targetBranch of the named project, unless into is given.
+func targetBranch(name string, into string) string {
+	if into != "" {
+		return into
+	}
+	if p := findProject(name); p != nil {
+		return p.branch()
+	}
+	return defaultBranch
+}
+
+// setProjects in the config, ready for writeConfig.
+func setProjects(projects []project) {
+	setConfig("Projects", projectTables(projects))
+}
+
+// projectTables of the config.
+// The TOML writer only takes maps, and empty fields are left out.
+func projectTables(projects []project) []map[string]interface{} {
+	tables := make([]map[string]interface{}, len(projects))
+	for i, p := range projects {
+		table := map[string]interface{}{"URL": p.URL}
+		for key, value := range map[string]string{
+			"Name":     p.Name,
+			"Branch":   p.Branch,
+			"Remote":   p.Remote,
+			"Strategy": string(p.Strategy),
+		} {
+			if value != "" {
+				table[key] = value
+			}
+		}
+		if len(p.Deps) != 0 {
+			table["Deps"] = p.Deps
+		}
+		tables[i] = table
+	}
+	return tables
+}
+
+// parseProject of an older config, which is either a URL or "url:branch".
+// A colon is only a branch after ".git", or in the path of the URL,
+// as ssh URLs have a colon after the host and others may have a port.
+func parseProject(s string) project {
+	if i := strings.LastIndex(s, ":"); i > 0 && strings.HasSuffix(s[:i], ".git") {
+		return project{URL: s[:i], Branch: s[i+1:]}
+	}
+	start := 0
+	if i := strings.Index(s, "://"); i >= 0 {
+		j := strings.Index(s[i+3:], "/")
+		if j < 0 {
+			return project{URL: s}
+		}
+		start = i + 3 + j
+	} else if i := strings.Index(s, ":"); i >= 0 && !strings.Contains(s[:i], "/") {
+		// user@host:path
+		start = i + 1
+	}
+	if i := strings.LastIndex(s[start:], ":"); i >= 0 {
+		i += start
+		return project{URL: s[:i], Branch: s[i+1:]}
+	}
+	return project{URL: s}
+}
+
+// migrateConfig converts the projects of an older config file from strings to tables,
+// and moves the MergeStrategies into them.
+// The file is rewritten once, so nothing changes afterwards.
+// If it can't be written, e.g. the system file, it is only migrated in memory.
+func migrateConfig(l *layer) {
+	entries, ok := l.viper.Get("Projects").([]interface{})
+	if !ok || len(entries) == 0 {
+		return
+	}
+	projects := make([]project, len(entries))
+	for i, entry := range entries {
+		s, ok := entry.(string)
+		if !ok {
+			return
+		}
+		projects[i] = parseProject(s)
+	}
+
+	// mergeStrategy was a list entry as URLs can't be used as keys in the config.
+	var strategies []struct {
+		URL      string
+		Strategy git.MergeStrategy
+	}
+	if err := l.viper.UnmarshalKey("MergeStrategies", &strategies); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	for i := range projects {
+		for _, s := range strategies {
+			if s.URL == projects[i].URL {
+				projects[i].Strategy = s.Strategy
+			}
+		}
+	}
+
+	// viper can't unset a key, so the layer is read again from its settings without the strategies.
+	settings := l.viper.AllSettings()
+	delete(settings, "mergestrategies")
+	settings["projects"] = projectTables(projects)
+	migrated := viper.New()
+	migrated.SetConfigFile(l.file)
+	migrated.SetConfigType("toml")
+	if err := migrated.MergeConfigMap(settings); err != nil {
+		fmt.Fprintln(os.Stderr, "Could not migrate the projects in", l.file+":", err)
+		return
+	}
+	l.viper = migrated
+	if err := l.viper.WriteConfig(); err != nil {
+		fmt.Fprintln(os.Stderr, "Could not migrate the projects in", l.file+":", err)
+		return
+	}
+	fmt.Fprintln(os.Stderr, "Migrated the projects to tables in", l.file)
+}
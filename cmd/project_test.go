@@ -0,0 +1,106 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"path"
+	"reflect"
+	"testing"
+
+	"github.com/git-depend/git-depend/pkg/git"
+	"github.com/spf13/viper"
+)
+
+func TestParseProject(t *testing.T) {
+	tests := []struct {
+		s    string
+		want project
+	}{
+		{"https://host/org/repo.git", project{URL: "https://host/org/repo.git"}},
+		{"https://host/org/repo", project{URL: "https://host/org/repo"}},
+		{"https://host:8443/org/repo", project{URL: "https://host:8443/org/repo"}},
+		{"https://host/org/repo.git:feature", project{URL: "https://host/org/repo.git", Branch: "feature"}},
+		{"https://host/org/repo:feature", project{URL: "https://host/org/repo", Branch: "feature"}},
+		{"https://host:8443/org/repo:feature", project{URL: "https://host:8443/org/repo", Branch: "feature"}},
+		{"git@host:org/repo.git", project{URL: "git@host:org/repo.git"}},
+		{"git@host:org/repo", project{URL: "git@host:org/repo"}},
+		{"git@host:org/repo.git:main", project{URL: "git@host:org/repo.git", Branch: "main"}},
+		{"git@host:org/repo:main", project{URL: "git@host:org/repo", Branch: "main"}},
+		{"repo.git:feature/x", project{URL: "repo.git", Branch: "feature/x"}},
+		{"/tmp/repo:feature/x", project{URL: "/tmp/repo", Branch: "feature/x"}},
+	}
+	for _, test := range tests {
+		if got := parseProject(test.s); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("parseProject(%q) = %+v, expected %+v", test.s, got, test.want)
+		}
+	}
+}
+
+func TestMigrateConfig(t *testing.T) {
+	file := path.Join(t.TempDir(), configName)
+	old := `Projects = ["https://host/org/foo.git:develop", "git@host:org/bar.git"]
+
+[[MergeStrategies]]
+  URL = "git@host:org/bar.git"
+  Strategy = "squash"
+`
+	if err := ioutil.WriteFile(file, []byte(old), 0644); err != nil {
+		t.Fatal(err)
+	}
+	migrateConfig(readLayer(t, file))
+
+	// The file is read again, as the next run would.
+	l := readLayer(t, file)
+	var projects []project
+	if err := l.viper.UnmarshalKey("Projects", &projects); err != nil {
+		t.Fatal(err)
+	}
+	want := []project{
+		{URL: "https://host/org/foo.git", Branch: "develop"},
+		{URL: "git@host:org/bar.git", Strategy: git.MergeSquash},
+	}
+	if !reflect.DeepEqual(projects, want) {
+		t.Fatalf("Unexpected projects: %+v", projects)
+	}
+	if l.viper.IsSet("MergeStrategies") {
+		t.Fatalf("The strategies should have been moved into the projects: %v", l.viper.Get("MergeStrategies"))
+	}
+
+	// A migrated file is left alone.
+	migrated, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	migrateConfig(l)
+	again, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(again) != string(migrated) {
+		t.Fatalf("The file should only be migrated once:\n%s\n%s", migrated, again)
+	}
+}
+
+// readLayer reads the config file as loadLayers does.
+func readLayer(t *testing.T, file string) *layer {
+	l := &layer{file: file, viper: viper.New()}
+	l.viper.SetConfigFile(file)
+	l.viper.SetConfigType("toml")
+	if err := l.viper.ReadInConfig(); err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func TestDependents(t *testing.T) {
+	projects := []project{
+		{URL: "https://host/foo.git", Deps: []string{"bar", "baz"}},
+		{Name: "qux", URL: "https://host/other.git", Deps: []string{"bar"}},
+		{URL: "https://host/bar.git"},
+	}
+	if got := dependents(projects, "bar"); !reflect.DeepEqual(got, []string{"foo", "qux"}) {
+		t.Fatalf("Expected foo and qux to depend on bar: %v", got)
+	}
+	if got := dependents(projects, "foo"); len(got) != 0 {
+		t.Fatalf("Nothing depends on foo: %v", got)
+	}
+}
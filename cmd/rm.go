@@ -1,34 +1,100 @@
 package cmd
 
 import (
+	"fmt"
+	"os"
+	"strings"
+
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
 
+var purge bool
+
 var rmCmd = &cobra.Command{
-	Use:   "rm",
+	Use:   "rm <project>...",
 	Short: "Remove a project from your dependency.",
-	Long:  `Remove a project from your dependency.`,
+	Long: `Remove projects from your dependency by name or URL.
+A project can't be removed while other projects depend on it, unless they are removed too.
+With --purge, their clones are deleted from the cache too.`,
+	Args: cobra.MinimumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		viper.Unmarshal(&cfg)
-		pros := make([]string, len(cfg.Projects))
+		var projects, removed []project
+		for _, p := range cfg.Projects {
+			if contains(args, p.URL) || contains(args, p.name()) {
+				removed = append(removed, p)
+			} else {
+				projects = append(projects, p)
+			}
+		}
 		for _, arg := range args {
-			for _, ele := range cfg.Projects {
-				if arg != ele {
-					pros = append(pros, ele)
-				}
+			found := false
+			for _, p := range removed {
+				found = found || arg == p.URL || arg == p.name()
+			}
+			if !found {
+				fmt.Printf("Unknown project %q.\n", arg)
+				os.Exit(1)
 			}
 		}
-		cfg.Projects = pros
-		viper.Set("Projects", cfg.Projects)
-		viper.WriteConfig()
+		failed := false
+		for _, p := range removed {
+			if names := dependents(projects, p.name()); len(names) != 0 {
+				fmt.Printf("Can't remove %s, as it is a dependency of %s.\n", p.name(), strings.Join(names, ", "))
+				failed = true
+			}
+		}
+		if failed {
+			os.Exit(1)
+		}
+
+		cfg.Projects = projects
+		setProjects(cfg.Projects)
+		if err := writeConfig(); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		for _, p := range removed {
+			fmt.Printf("Removed: %s %s\n", p.name(), p.URL)
+		}
+
+		if !purge {
+			return
+		}
+		cache := newCache()
+		for _, p := range removed {
+			dir, err := cache.RemoveRepository(p.URL)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			fmt.Println("Purged:", dir)
+		}
 	},
 }
 
 func init() {
+	rmCmd.Flags().BoolVar(&purge, "purge", false, "delete the clones of the projects from the cache")
 	rootCmd.AddCommand(rmCmd)
 }
 
-func remove(slice []string, s int) []string {
-	return append(slice[:s], slice[s+1:]...)
+// dependents returns the names of the projects which depend on the named one.
+func dependents(projects []project, name string) []string {
+	var names []string
+	for _, p := range projects {
+		if contains(p.Deps, name) {
+			names = append(names, p.name())
+		}
+	}
+	return names
+}
+
+func contains(slice []string, s string) bool {
+	for _, ele := range slice {
+		if ele == s {
+			return true
+		}
+	}
+	return false
 }
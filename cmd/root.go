@@ -1,11 +1,22 @@
 package cmd
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"os"
+	"os/signal"
 	"path"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/git-depend/git-depend/pkg/depend"
+	"github.com/git-depend/git-depend/pkg/git"
+	"github.com/git-depend/git-depend/pkg/git/gogit"
 	homedir "github.com/mitchellh/go-homedir"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -14,7 +25,57 @@ import (
 type config struct {
 	Author   string
 	Email    string
-	Projects []string
+	Projects []project
+	// URLRules declare equivalent URLs, e.g. a mirror and its upstream.
+	URLRules []git.URLRule
+	// MaxWorkers is the number of repositories cloned or fetched at once.
+	MaxWorkers int
+	// MaxPerHost is the number of git processes allowed to talk to one host.
+	MaxPerHost int
+	// CloneStrategies for large repositories.
+	CloneStrategies []cloneStrategy
+	// NotesMergeStrategy resolves local notes which have diverged from the remote.
+	NotesMergeStrategy git.NotesMergeStrategy
+	// Offline works entirely from the cache.
+	Offline bool
+	// Timeouts maps a git operation such as clone, fetch or push to a duration, e.g. "10m".
+	// The "default" entry applies to all other operations.
+	Timeouts map[string]time.Duration
+	// Backend is "exec" to run the git binary, or "go" to use go-git.
+	Backend string
+	// Credentials for projects which don't use the user's git configuration.
+	Credentials []credentials
+	// Remotes are forks of the projects, which branches can be merged from.
+	Remotes []remote
+	// Signing signs the merge commits and the notes commits with a GPG or SSH key.
+	Signing git.Signing
+	// Namespace of the dependency notes, for graphs which share repositories.
+	Namespace string
+}
+
+// cloneStrategy is a list entry as URLs can't be used as keys in the config.
+type cloneStrategy struct {
+	URL   string
+	Mode  git.CloneMode
+	Depth int
+}
+
+// remote is a fork of a project.
+// Forks of several projects share a name, e.g. the name of their owner.
+type remote struct {
+	Name string
+	// Project is the name or URL of the project which was forked.
+	Project string
+	URL     string
+}
+
+// credentials is a list entry as URLs can't be used as keys in the config.
+type credentials struct {
+	URL              string
+	SSHKey           string
+	CredentialHelper string
+	// HTTPHeaderEnv is the name of an environment variable, not the header itself.
+	HTTPHeaderEnv string
 }
 
 func (cfg *config) String() string {
@@ -35,7 +96,20 @@ var rootCmd = &cobra.Command{
 }
 
 func Execute() {
-	if err := rootCmd.Execute(); err != nil {
+	// Cancel the context on Ctrl-C so that all the child git processes are stopped.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+	}()
+
+	// Every git command of this run is tagged with the ID in the trace.
+	ctx = git.WithTransaction(ctx, newTransactionID())
+
+	if err := rootCmd.ExecuteContext(ctx); err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
@@ -43,35 +117,48 @@ func Execute() {
 
 func init() {
 	cobra.OnInitialize(initConfig)
-	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.git-depend)")
+	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "user config file (default is $HOME/.git-depend.toml)")
+	rootCmd.PersistentFlags().Bool("offline", false, "work entirely from the cache without fetching")
+	bindFlag("offline", rootCmd.PersistentFlags().Lookup("offline"))
+	rootCmd.PersistentFlags().Bool("trace", false, "log every git command as JSON to stderr, or to the file in GIT_DEPEND_TRACE")
+	bindFlag("trace", rootCmd.PersistentFlags().Lookup("trace"))
 }
 
-// initConfig reads in config file.
+// initConfig reads in the config files, see loadLayers.
 func initConfig() {
-	viper.SetConfigType("toml")
-	if cfgFile != "" {
-		viper.SetConfigFile(cfgFile)
-	} else {
-		configName := ".git-depend"
-		// Find home directory.
-		home, err := homedir.Dir()
-		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
-		}
-		// Search config in home directory with name ".git-depend.toml".
-		viper.AddConfigPath(home)
-		viper.SetConfigName(configName)
-		// Create a configfile if one doesn't exist.
-		viper.SafeWriteConfig()
+	if err := loadLayers(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
 	}
+	// On stderr, so that the output of commands such as ls --json can be parsed.
+	fmt.Fprintln(os.Stderr, "Using config files:", strings.Join(configFiles(), ", "))
+	initTrace()
+}
 
-	err := viper.ReadInConfig()
+// initTrace turns on the trace with --trace or GIT_DEPEND_TRACE.
+func initTrace() {
+	file := os.Getenv("GIT_DEPEND_TRACE")
+	if file == "" {
+		if viper.GetBool("trace") {
+			git.SetTrace(os.Stderr)
+		}
+		return
+	}
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
-	fmt.Println("Using config file:", viper.ConfigFileUsed())
+	git.SetTrace(f)
+}
+
+// newTransactionID is random so that runs on different machines can be told apart.
+func newTransactionID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(b)
 }
 
 func getCacheDir() string {
@@ -82,3 +169,143 @@ func getCacheDir() string {
 	}
 	return path.Join(home, ".cache", "git-depend")
 }
+
+// newCache opens the cache using the configured options.
+func newCache() *git.Cache {
+	return newCacheAt(getCacheDir(), false)
+}
+
+// newCacheAt opens a cache in the directory using the configured options.
+func newCacheAt(dir string, dryRun bool) *git.Cache {
+	for operation, timeout := range cfg.Timeouts {
+		git.SetTimeout(operation, timeout)
+	}
+	strategies := make(map[string]git.CloneStrategy, len(cfg.CloneStrategies))
+	for _, s := range cfg.CloneStrategies {
+		strategies[s.URL] = git.CloneStrategy{Mode: s.Mode, Depth: s.Depth}
+	}
+	creds := make(map[string]git.Credentials, len(cfg.Credentials))
+	for _, c := range cfg.Credentials {
+		creds[c.URL] = git.Credentials{SSHKey: c.SSHKey, CredentialHelper: c.CredentialHelper, HTTPHeaderEnv: c.HTTPHeaderEnv}
+	}
+	cache, err := git.NewCacheWithOptions(dir, git.CacheOptions{
+		URLRules:           cfg.URLRules,
+		MaxWorkers:         cfg.MaxWorkers,
+		MaxPerHost:         cfg.MaxPerHost,
+		CloneStrategies:    strategies,
+		NotesMergeStrategy: cfg.NotesMergeStrategy,
+		Offline:            cfg.Offline,
+		Backend:            newBackend(),
+		Credentials:        creds,
+		Remotes:            remoteNames(),
+		Signing:            cfg.Signing,
+		DryRun:             dryRun,
+	})
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	return cache
+}
+
+// newBackend returns the configured git backend.
+func newBackend() git.Backend {
+	switch cfg.Backend {
+	case "", "exec":
+		return git.ExecBackend{}
+	case "go":
+		return gogit.Backend{}
+	}
+	fmt.Printf("Unknown backend %q, expected \"exec\" or \"go\".\n", cfg.Backend)
+	os.Exit(1)
+	return nil
+}
+
+// newGraph of the configured projects.
+func newGraph(cache *git.Cache) *depend.Graph {
+	type repo struct {
+		Name     string
+		Url      string
+		Deps     []string          `json:",omitempty"`
+		Strategy git.MergeStrategy `json:",omitempty"`
+	}
+	repos := make([]repo, len(cfg.Projects))
+	for i, p := range cfg.Projects {
+		repos[i] = repo{p.name(), p.URL, p.Deps, p.Strategy}
+	}
+	data, err := json.Marshal(repos)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	graph, err := depend.NewGraph(cache, data)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if err := graph.SetNamespace(cfg.Namespace); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	return graph
+}
+
+// remoteNames maps the URL of each fork to its name.
+func remoteNames() map[string]string {
+	names := make(map[string]string, len(cfg.Remotes))
+	for _, r := range cfg.Remotes {
+		names[r.URL] = r.Name
+	}
+	return names
+}
+
+// setRemotes merges the branch of every project from its fork with the name.
+// Without a name, each project uses the fork of its Remote, if it has one.
+// Projects without a fork of that name use their own branch.
+func setRemotes(requests *depend.Requests, name string) {
+	found := false
+	for _, p := range cfg.Projects {
+		remote := name
+		if remote == "" {
+			remote = p.Remote
+		}
+		if remote == "" {
+			continue
+		}
+		fork := findRemote(remote, &p)
+		if fork == nil {
+			if name == "" {
+				fmt.Printf("%s: unknown remote %q.\n", p.name(), remote)
+				os.Exit(1)
+			}
+			continue
+		}
+		found = true
+		if err := requests.SetFromURL(p.name(), fork.URL); err != nil {
+			fmt.Printf("%s: %v\n", p.name(), err)
+			os.Exit(1)
+		}
+	}
+	if name != "" && !found {
+		fmt.Printf("Unknown remote %q.\n", name)
+		os.Exit(1)
+	}
+}
+
+// findRemote returns the fork of the project with the name, or nil.
+func findRemote(name string, p *project) *remote {
+	for i, r := range cfg.Remotes {
+		if r.Name == name && (r.Project == p.URL || r.Project == p.name()) {
+			return &cfg.Remotes[i]
+		}
+	}
+	return nil
+}
+
+// requireOnline exits if a command which changes the remotes is run offline.
+func requireOnline(cmd *cobra.Command) {
+	if cfg.Offline {
+		fmt.Printf("%s changes the remote repositories and can't run with --offline.\n", cmd.CommandPath())
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/git-depend/git-depend/pkg/depend"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var statusInto string
+var statusFrom string
+
+var statusCmd = &cobra.Command{
+	Use:   "status <branch>",
+	Short: "Show the state of a branch in every project.",
+	Long: `Show, for every project, whether the branch exists on the remote, how many
+commits it is ahead of and behind the target branch, who holds the lock,
+whether its dependency note matches the graph and whether it can be merged.
+Nothing is locked, so this never blocks other merges. It exits with an error
+unless every project is mergeable.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		viper.Unmarshal(&cfg)
+		cache := newCache()
+		graph := newGraph(cache)
+		requests := graph.NewRequests()
+		for _, name := range graph.Names() {
+			if err := requests.AddRequest(name, args[0], targetBranch(name, statusInto), cfg.Author, cfg.Email); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		}
+
+		setRemotes(requests, statusFrom)
+
+		failed := false
+		for _, status := range requests.Status(cmd.Context()) {
+			if !status.Mergeable() {
+				failed = true
+			}
+			printStatus(os.Stdout, status)
+		}
+		if failed {
+			os.Exit(1)
+		}
+	},
+}
+
+// printStatus of one project.
+func printStatus(w io.Writer, status *depend.Status) {
+	switch {
+	case status.Err != nil:
+		fmt.Fprintf(w, "error: %s\n\tError: %v\n", status.Name, status.Err)
+		return
+	case !status.Exists:
+		fmt.Fprintf(w, "missing: %s has no branch %s\n", status.Name, status.From)
+		return
+	case status.Mergeable():
+		fmt.Fprintf(w, "mergeable: %s\n", status.Name)
+	default:
+		fmt.Fprintf(w, "blocked: %s\n", status.Name)
+	}
+	fmt.Fprintf(w, "\t%d ahead, %d behind %s\n", status.Ahead, status.Behind, status.To)
+	fmt.Fprintf(w, "\tdependencies: %s\n", status.Deps)
+	if status.Lock != nil {
+		owner := status.Lock.Owner
+		if owner == "" {
+			owner = "unknown"
+		}
+		transaction := status.Lock.Transaction
+		if transaction == "" {
+			transaction = "an unknown transaction"
+		}
+		fmt.Fprintf(w, "\tlocked by %s in %s\n", owner, transaction)
+	}
+	for _, file := range status.Conflicts {
+		fmt.Fprintln(w, "\tconflict: "+file)
+	}
+}
+
+func init() {
+	statusCmd.Flags().StringVar(&statusFrom, "from", "", "the name of the remotes to take the branch from, e.g. the owner of the forks (defaults to each project's remote)")
+	statusCmd.Flags().StringVar(&statusInto, "into", "", "the branch to merge into (defaults to each project's branch)")
+	rootCmd.AddCommand(statusCmd)
+}
@@ -0,0 +1,29 @@
+package cmd
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/git-depend/git-depend/pkg/depend"
+)
+
+func TestPrintStatusLock(t *testing.T) {
+	status := &depend.Status{
+		Name:   "foo",
+		From:   "feature",
+		To:     "master",
+		Exists: true,
+		Ahead:  1,
+		Deps:   depend.DepsCurrent,
+		Lock:   &depend.Holder{ID: "foo", Transaction: "0123abcd", Owner: "Eric <eric@email.com>"},
+	}
+	var out bytes.Buffer
+	printStatus(&out, status)
+	want := "blocked: foo\n" +
+		"\t1 ahead, 0 behind master\n" +
+		"\tdependencies: current\n" +
+		"\tlocked by Eric <eric@email.com> in 0123abcd\n"
+	if out.String() != want {
+		t.Fatalf("Unexpected status:\n%s", out.String())
+	}
+}
@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var verifyInto string
+var verifySince time.Duration
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify <transaction>",
+	Short: "Verify the signatures of a transaction.",
+	Long: `Check the signatures on the commits of a merge, and on the commits of the
+git-depend notes which it wrote. The transaction is printed by merge.
+SSH signatures are checked against Signing.AllowedSigners in the config.
+Only the commits of the last --since are searched, as the whole history is slow
+to search; use --since 0 to search all of it.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		viper.Unmarshal(&cfg)
+		cache := newCache()
+		graph := newGraph(cache)
+		var since time.Time
+		if verifySince > 0 {
+			since = time.Now().Add(-verifySince)
+		}
+		results, err := graph.VerifyTransaction(cmd.Context(), args[0], verifyInto, since)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		failed := false
+		for _, result := range results {
+			if result.Err != nil {
+				failed = true
+				fmt.Printf("bad: %s %s %s\n\tError: %v\n", result.Name, result.Ref, result.Commit, result.Err)
+				continue
+			}
+			fmt.Printf("good: %s %s %s\n", result.Name, result.Ref, result.Commit)
+		}
+		if failed {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	verifyCmd.Flags().StringVar(&verifyInto, "into", "master", "the branch which was merged into")
+	verifyCmd.Flags().DurationVar(&verifySince, "since", 30*24*time.Hour, "how long ago the transaction was merged at most")
+	rootCmd.AddCommand(verifyCmd)
+}
@@ -0,0 +1,81 @@
+package depend
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/git-depend/git-depend/pkg/git"
+)
+
+// CheckResult says whether the branch of a request merges cleanly into its target.
+type CheckResult struct {
+	Name string
+	From string
+	To   string
+	// Conflicts are the files which don't merge cleanly.
+	Conflicts []string
+	// Err is set if the check couldn't be done, e.g. the branch doesn't exist.
+	Err error
+}
+
+// Clean returns true if the branch merges without conflicts.
+func (result *CheckResult) Clean() bool {
+	return result.Err == nil && len(result.Conflicts) == 0
+}
+
+// ConflictError is returned when the branches of some requests don't merge cleanly.
+// errors.Is(err, git.ErrConflict) is true for it.
+type ConflictError struct {
+	Results []*CheckResult
+}
+
+func (e *ConflictError) Error() string {
+	conflicts := make([]string, len(e.Results))
+	for i, result := range e.Results {
+		conflicts[i] = fmt.Sprintf("%s conflicts with %s in %s: %s", result.From, result.To, result.Name, strings.Join(result.Conflicts, ", "))
+	}
+	return strings.Join(conflicts, "\n")
+}
+
+func (e *ConflictError) Is(target error) bool {
+	return target == git.ErrConflict
+}
+
+// Check whether every request merges cleanly, without taking any locks.
+// The results are sorted by name.
+func (requests *Requests) Check(ctx context.Context) []*CheckResult {
+	results := make([]*CheckResult, 0, len(requests.table))
+	for node, request := range requests.table {
+		result := &CheckResult{
+			Name: node.name,
+			From: request.From,
+			To:   request.To,
+		}
+		result.Conflicts, result.Err = requests.cache.CanMerge(ctx, node.url, request.From, request.To, request.options(node))
+		results = append(results, result)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Name < results[j].Name
+	})
+	return results
+}
+
+// preflight returns an error if any request can't be merged cleanly.
+// This runs before the locks are taken, so nobody else is blocked by a merge which would fail.
+func (requests *Requests) preflight(ctx context.Context) error {
+	var conflicts []*CheckResult
+	for _, result := range requests.Check(ctx) {
+		if result.Err != nil {
+			return fmt.Errorf("%s: %w", result.Name, result.Err)
+		}
+		if !result.Clean() {
+			conflicts = append(conflicts, result)
+		}
+	}
+	if len(conflicts) != 0 {
+		return &ConflictError{conflicts}
+	}
+	return nil
+}
@@ -1,10 +1,13 @@
 package depend
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
+	"regexp"
+	"sort"
+	"strings"
 
 	"github.com/git-depend/git-depend/pkg/git"
 	"github.com/git-depend/git-depend/pkg/utils"
@@ -12,6 +15,9 @@ import (
 
 var ref_deps_name string = "git-depend-deps"
 
+// validNamespace is a single component of a ref name.
+var validNamespace = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9._-]*$`)
+
 type NodeTable map[string]*Node
 
 // Graph node only contains its direct dependencies.
@@ -22,13 +28,21 @@ type Graph struct {
 	table NodeTable
 	edges []*Node
 	cache *git.Cache
+	// namespace separates the dependency notes of graphs which share repositories.
+	namespace string
 }
 
 // Node of the tree.
 type Node struct {
 	name string
 	url  string
+	// key is the normalised url which identifies the repository.
+	key  string
 	deps []*Node
+	// strategy is how requests for this repository are merged, unless the request sets one.
+	strategy git.MergeStrategy
+	// depsRef is the notes ref with the dependencies, which depends on the namespace of the graph.
+	depsRef string
 }
 
 type NodeCycleError struct {
@@ -44,9 +58,10 @@ func (e *NodeCycleError) Error() string {
 // repo contains information about the repository
 // The direct dependencies in this struct are the names of other repos.
 type repo struct {
-	Name string   `json:"Name"`
-	URL  string   `json:"Url"`
-	Deps []string `json:"Deps,omitempty"`
+	Name     string            `json:"Name"`
+	URL      string            `json:"Url"`
+	Deps     []string          `json:"Deps,omitempty"`
+	Strategy git.MergeStrategy `json:"Strategy,omitempty"`
 }
 
 // NewGraph reads in the JSON data and creates the graph.
@@ -84,17 +99,66 @@ func (node *Node) Children() []*Node {
 }
 
 // URLs returns all URLs in the graph.
+// Equivalent URLs are only returned once.
 func (graph *Graph) URLs() []string {
-	urls := utils.NewSet()
+	keys := utils.NewSet()
+	var urls []string
 	for _, e := range graph.edges {
-		urls.Add(e.url)
-		for _, node := range e.Children() {
-			if !urls.Exists(node.url) {
-				urls.Add(node.url)
+		for _, node := range append([]*Node{e}, e.Children()...) {
+			if !keys.Exists(node.key) {
+				keys.Add(node.key)
+				urls = append(urls, node.url)
 			}
 		}
 	}
-	return urls.Iterate()
+	return urls
+}
+
+// Names of all the nodes, sorted.
+func (graph *Graph) Names() []string {
+	names := make([]string, 0, len(graph.table))
+	for name := range graph.table {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewRequests for the nodes of the graph.
+func (graph *Graph) NewRequests() *Requests {
+	return NewRequests(graph.table, graph.cache)
+}
+
+// SetNamespace of the dependency notes, so that graphs which share repositories
+// don't overwrite each other's notes.
+// The notes are in refs/notes/git-depend/<namespace>/deps.
+// The lock is still per repository, so merges of different graphs exclude each other.
+func (graph *Graph) SetNamespace(namespace string) error {
+	if namespace != "" && (!validNamespace.MatchString(namespace) || strings.HasSuffix(namespace, ".lock") || strings.Contains(namespace, "..")) {
+		return fmt.Errorf("invalid namespace: %q", namespace)
+	}
+	graph.namespace = namespace
+	for _, node := range graph.table {
+		node.depsRef = depsNotesRef(namespace)
+	}
+	return nil
+}
+
+// depsNotesRef is the notes ref with the dependencies of the graphs in the namespace.
+// Graphs without a namespace share ref_deps_name.
+func depsNotesRef(namespace string) string {
+	if namespace == "" {
+		return ref_deps_name
+	}
+	return "git-depend/" + namespace + "/deps"
+}
+
+// normaliseURL uses the rules of the cache if there is one.
+func (graph *Graph) normaliseURL(url string) string {
+	if graph.cache == nil {
+		return git.NormaliseURL(url)
+	}
+	return graph.cache.NormaliseURL(url)
 }
 
 // populateTable will create the NodeTable.
@@ -108,15 +172,28 @@ func (graph *Graph) populateTableFromJson(data []byte) error {
 	table := make(NodeTable, len(repo_list))
 	// Collect a map which contains a list of the dependencies.
 	deps := make(map[string][]string)
+	// Equivalent URLs are the same repository.
+	keys := make(map[string]string)
 	for _, repo := range repo_list {
+		key := graph.normaliseURL(repo.URL)
+		if name, ok := keys[key]; ok && name != repo.Name {
+			return fmt.Errorf("repositories %s and %s have the same URL: %s", name, repo.Name, key)
+		}
+		keys[key] = repo.Name
+		if err := repo.Strategy.Valid(); err != nil {
+			return fmt.Errorf("repository %s: %w", repo.Name, err)
+		}
 		// Populate a table which contains a list of dependencies.
 		if _, ok := deps[repo.Name]; !ok {
 			// Collect the list of dependencies.
 			deps[repo.Name] = repo.Deps
 			// Don't collect the dependencies yet.
 			table[repo.Name] = &Node{
-				name: repo.Name,
-				url:  repo.URL,
+				name:     repo.Name,
+				url:      repo.URL,
+				key:      key,
+				strategy: repo.Strategy,
+				depsRef:  depsNotesRef(graph.namespace),
 			}
 		} else {
 			return errors.New("Duplicate key: " + repo.Name)
@@ -243,104 +320,109 @@ func (node *Node) directChildRepos() []*repo {
 	repos := make([]*repo, len(deps))
 	for i, node := range deps {
 		repos[i] = &repo{
-			node.name,
-			node.url,
-			node.dependencyNames(),
+			Name: node.name,
+			URL:  node.url,
+			Deps: node.dependencyNames(),
 		}
 	}
 	return repos
 }
 
 //PopulateDependencyNotes with dependency information.
-func (node *Node) PopulateDependencyNotes(ID string, cache *git.Cache) error {
-	lock := NewLock(ID, cache)
-	repos := node.directChildRepos()
-	log.Println(repos)
-	data, err := json.MarshalIndent(repos, "", "\t")
-	if err != nil {
-		return err
-	}
-	if err := lock.writeLock(node); err != nil {
-		return err
-	}
-	if err = cache.AddNotes(node.url, ref_deps_name, string(data)); err != nil {
-		return err
-	}
-	if err = cache.PushNotes(node.url, ref_deps_name); err != nil {
-		return err
-	}
-	if err := lock.removeLock(node); err != nil {
-		return err
-	}
-	return nil
+// The note is attached to the tip of the branch, so a branch can have its own dependencies.
+func (node *Node) PopulateDependencyNotes(ctx context.Context, ID string, branch string, cache *git.Cache) error {
+	return node.writeDependencyNotes(ctx, NewLock(ID, cache), branch)
+}
+
+// WriteResult of writing the dependency note of one repository.
+type WriteResult struct {
+	Name   string
+	Branch string
+	// Err is set if the note couldn't be written, e.g. the repository is locked.
+	Err error
 }
 
 // WriteAllDependencyNotes to this node and the children.
-func (graph *Graph) WriteAllDependencyNotes(parent *Node, ID string) error {
+// Every node is written even if another fails, and the first error is returned.
+func (graph *Graph) WriteAllDependencyNotes(ctx context.Context, parent *Node, ID string, branch string) error {
 	lock := NewLock(ID, graph.cache)
-	for _, n := range parent.Children() {
-		n.writeDependencyNotes(lock)
+	var first error
+	for _, n := range append([]*Node{parent}, parent.Children()...) {
+		if err := n.writeDependencyNotes(ctx, lock, branch); err != nil && first == nil {
+			first = fmt.Errorf("%s: %w", n.name, err)
+		}
 	}
-	return nil
+	return first
 }
 
 // WriteDependencyNotes to only this node.
-func (graph *Graph) WriteDependencyNotes(node *Node, ID string) error {
+func (graph *Graph) WriteDependencyNotes(ctx context.Context, node *Node, ID string, branch string) error {
+	return node.writeDependencyNotes(ctx, NewLock(ID, graph.cache), branch)
+}
+
+// CommitDependencyNotes writes the note of every node in the graph to the tip of its branch.
+// Each repository is locked while its note is written, with the owner recorded in the lock.
+// The results are sorted by name, and a node without a branch fails.
+func (graph *Graph) CommitDependencyNotes(ctx context.Context, ID string, owner string, branches map[string]string) []*WriteResult {
 	lock := NewLock(ID, graph.cache)
-	node.writeDependencyNotes(lock)
-	return nil
+	lock.Owner = owner
+	results := make([]*WriteResult, 0, len(graph.table))
+	for _, name := range graph.Names() {
+		result := &WriteResult{Name: name, Branch: branches[name]}
+		if result.Branch == "" {
+			result.Err = fmt.Errorf("no branch to write the dependencies of %s to", name)
+		} else {
+			result.Err = graph.table[name].writeDependencyNotes(ctx, lock, result.Branch)
+		}
+		results = append(results, result)
+	}
+	return results
 }
 
-// writeDependencyNotes will write the note to the repository.
-func (node *Node) writeDependencyNotes(lock *lock) error {
-	cache := lock.cache
+// writeDependencyNotes will write the note to the tip of the branch in the repository.
+// Any note which the tip already has is replaced.
+// The lock is released even if the note couldn't be written.
+func (node *Node) writeDependencyNotes(ctx context.Context, lock *lock, branch string) error {
 	repos := node.directChildRepos()
 	data, err := json.MarshalIndent(repos, "", "\t")
 	if err != nil {
 		return err
 	}
-	if err := lock.writeLock(node); err != nil {
-		return err
-	}
-	byte_s, err := cache.ListNotes(node.url, ref_deps_name)
-	if err != nil {
-		return err
-	}
-	_, object, err := parseUniqueNote(byte_s)
-	if err != nil {
-		return err
-	}
-	if object != "" {
-		if err := cache.RemoveNotes(node.url, ref_deps_name, object); err != nil {
-			return err
+	if err := lock.writeLock(ctx, node); err != nil {
+		if lock.held {
+			lock.removeLock(ctx, node)
 		}
-	}
-	if err := cache.AddNotes(node.url, ref_deps_name, string(data)); err != nil {
 		return err
 	}
-	if err := cache.PushNotes(node.url, ref_deps_name); err != nil {
+	err = node.setDependencyNotes(ctx, lock.cache, branch, string(data))
+	if unlockErr := lock.removeLock(ctx, node); err == nil {
+		err = unlockErr
+	}
+	return err
+}
+
+// setDependencyNotes on the tip of the branch and push them.
+func (node *Node) setDependencyNotes(ctx context.Context, cache *git.Cache, branch string, note string) error {
+	if err := cache.FetchNotes(ctx, node.url, node.depsRef); err != nil {
 		return err
 	}
-	if err := lock.removeLock(node); err != nil {
+	if err := cache.SetNotes(ctx, node.url, node.depsRef, branchTip(branch), note); err != nil {
 		return err
 	}
-	return nil
+	return cache.PushNotes(ctx, node.url, node.depsRef)
 }
 
-//readDependencyNotes from the graph and return the byte data.
-func (graph *Graph) readDependencyNotes(node *Node) ([]byte, error) {
+//readDependencyNotes from the tip of the branch and return the byte data.
+// Returns an error wrapping git.ErrNoteMissing if the tip has no dependencies.
+func (graph *Graph) readDependencyNotes(ctx context.Context, node *Node, branch string) ([]byte, error) {
 	cache := graph.cache
-	byte_s, err := cache.ListNotes(node.url, ref_deps_name)
-	if err != nil {
+	if err := cache.FetchNotes(ctx, node.url, node.depsRef); err != nil {
 		return nil, err
 	}
-	_, object, err := parseUniqueNote(byte_s)
-	if err != nil {
-		return nil, err
-	}
-	byte_s, err = cache.ShowNotes(node.url, ref_deps_name, object)
-	if err != nil {
-		return nil, err
-	}
-	return byte_s, nil
+	return cache.ShowNotes(ctx, node.url, node.depsRef, branchTip(branch))
+}
+
+// branchTip is the revision of the branch on the upstream.
+func branchTip(branch string) string {
+	return "origin/" + branch
 }
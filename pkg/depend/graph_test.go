@@ -1,7 +1,9 @@
 package depend
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"os"
 	"os/exec"
 	"path"
@@ -33,6 +35,57 @@ func TestCiruclar(t *testing.T) {
 	}
 }
 
+func TestDuplicateURL(t *testing.T) {
+	url := createLocalGitRepo(t)
+	repos := []repo{
+		{
+			Name: "foo",
+			URL:  url,
+			Deps: []string{"bar"},
+		},
+		{
+			Name: "bar",
+			URL:  url + "/",
+		},
+	}
+	data, err := json.Marshal(repos)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewGraph(createLocalGitCache(t), data); err == nil {
+		t.Fatal("Equivalent URLs should be the same repository.")
+	}
+}
+
+func TestGraphStrategy(t *testing.T) {
+	repos := []repo{
+		{
+			Name:     "foo",
+			URL:      "https://fake/foo.git",
+			Strategy: git.MergeSquash,
+		},
+	}
+	data, err := json.Marshal(repos)
+	if err != nil {
+		t.Fatal(err)
+	}
+	graph, err := NewGraph(createLocalGitCache(t), data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strategy := graph.table["foo"].strategy; strategy != git.MergeSquash {
+		t.Fatalf("Unexpected strategy: %s", strategy)
+	}
+
+	repos[0].Strategy = "octopus"
+	if data, err = json.Marshal(repos); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewGraph(createLocalGitCache(t), data); err == nil {
+		t.Fatal("Should not accept an unknown strategy.")
+	}
+}
+
 func TestMultiGraph(t *testing.T) {
 	graph, err := NewGraph(createLocalGitCache(t), createSimpleLocalMultiGraph(t))
 	if err != nil {
@@ -112,10 +165,10 @@ func TestWriteDependencyToNode(t *testing.T) {
 	if !ok {
 		t.Fatalf("Graph does not contain foo.")
 	}
-	if err := node.PopulateDependencyNotes("id", cache); err != nil {
+	if err := node.PopulateDependencyNotes(context.Background(), "id", "master", cache); err != nil {
 		t.Fatal("Could not write: " + err.Error())
 	}
-	data, err := cache.ShowNotes(node.url, ref_deps_name, "")
+	data, err := cache.ShowNotes(context.Background(), node.url, ref_deps_name, "origin/master")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -133,10 +186,10 @@ func TestWriteDependencyToNode(t *testing.T) {
 	if !repoContains(repos, "baz") {
 		t.Fatal("Expected to conatin bar: " + string(data))
 	}
-	if err := graph.WriteDependencyNotes(node, "id"); err != nil {
+	if err := graph.WriteDependencyNotes(context.Background(), node, "id", "master"); err != nil {
 		t.Fatal("Could not write: " + err.Error())
 	}
-	data_read, err := graph.readDependencyNotes(node)
+	data_read, err := graph.readDependencyNotes(context.Background(), node, "master")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -172,10 +225,10 @@ func TestWriteDependencyToNodes(t *testing.T) {
 	if !ok {
 		t.Fatalf("Graph does not contain foo.")
 	}
-	if err := node.PopulateDependencyNotes("id", cache); err != nil {
+	if err := node.PopulateDependencyNotes(context.Background(), "id", "master", cache); err != nil {
 		t.Fatal("Could not write: " + err.Error())
 	}
-	data, err := cache.ShowNotes(node.url, ref_deps_name, "")
+	data, err := cache.ShowNotes(context.Background(), node.url, ref_deps_name, "origin/master")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -193,11 +246,99 @@ func TestWriteDependencyToNodes(t *testing.T) {
 	if !repoContains(repos, "baz") {
 		t.Fatal("Expected to conatin bar: " + string(data))
 	}
-	if err := graph.WriteAllDependencyNotes(node, "id"); err != nil {
+	if err := graph.WriteAllDependencyNotes(context.Background(), node, "id", "master"); err != nil {
 		t.Fatal("Could not write: " + err.Error())
 	}
 }
 
+func TestCommitDependencyNotes(t *testing.T) {
+	graph, fake, remotes := createFakeGraph(t)
+	remotes["bar"].AddNote(ref_lock_name, "", `{"Id":"other"}`)
+	fake.FailOn("SetNotes", "https://fake/baz.git", errors.New("could not write"))
+
+	branches := map[string]string{"foo": "master", "bar": "master", "baz": "master"}
+	results := graph.CommitDependencyNotes(context.Background(), "id", "Eric <eric@email.com>", branches)
+	if len(results) != 3 {
+		t.Fatalf("Expected a result for each repository: %v", results)
+	}
+	bar, baz, foo := results[0], results[1], results[2]
+	if foo.Name != "foo" || foo.Err != nil {
+		t.Fatal("Could not write foo: ", foo.Err)
+	}
+	if !errors.Is(bar.Err, git.ErrLockHeld) {
+		t.Fatal("bar should be locked: ", bar.Err)
+	}
+	if baz.Err == nil {
+		t.Fatal("baz should have failed.")
+	}
+
+	notes := remotes["foo"].Notes(ref_deps_name)
+	var repos []*repo
+	if err := json.Unmarshal([]byte(notes[remotes["foo"].Branch("master")[0]]), &repos); err != nil {
+		t.Fatal(err)
+	}
+	if len(repos) != 2 || !repoContains(repos, "bar") || !repoContains(repos, "baz") {
+		t.Fatalf("Expected foo to depend on bar and baz: %v", notes)
+	}
+	if len(remotes["bar"].Notes(ref_lock_name)) != 1 {
+		t.Fatal("The other lock should still be held.")
+	}
+	delete(remotes, "bar")
+	assertUnlocked(t, remotes)
+
+	results = graph.CommitDependencyNotes(context.Background(), "id", "", map[string]string{"foo": "master"})
+	if results[0].Err == nil || results[1].Err == nil || results[2].Err != nil {
+		t.Fatalf("Only foo has a branch: %v", results)
+	}
+}
+
+func TestNamespaces(t *testing.T) {
+	cache := createLocalGitCache(t)
+	data := createDeepLocalGraph(t)
+	graphs := make([]*Graph, 2)
+	for i, namespace := range []string{"first", "second"} {
+		graph, err := NewGraph(cache, data)
+		if err != nil {
+			t.Fatal("Could not create graph: " + err.Error())
+		}
+		if err := graph.SetNamespace(namespace); err != nil {
+			t.Fatal(err)
+		}
+		graphs[i] = graph
+	}
+	// Only the first graph has foo depend on bar.
+	graphs[0].table["foo"].deps = graphs[0].table["foo"].deps[:1]
+
+	ctx := context.Background()
+	for _, graph := range graphs {
+		if err := graph.table["foo"].PopulateDependencyNotes(ctx, "id", "master", cache); err != nil {
+			t.Fatal("Could not write: " + err.Error())
+		}
+	}
+	for i, expected := range []int{1, 2} {
+		data, err := graphs[i].readDependencyNotes(ctx, graphs[i].table["foo"], "master")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var repos []*repo
+		if err := json.Unmarshal(data, &repos); err != nil {
+			t.Fatal(err)
+		}
+		if len(repos) != expected {
+			t.Fatalf("Expected %d dependencies in %s: %s", expected, graphs[i].namespace, string(data))
+		}
+	}
+	if _, err := cache.ShowNotes(ctx, graphs[0].table["foo"].url, ref_deps_name, "origin/master"); err == nil {
+		t.Fatal("Should not write the notes without a namespace.")
+	}
+
+	for _, namespace := range []string{"a/b", ".hidden", "a..b", "graph.lock", "a b"} {
+		if err := graphs[0].SetNamespace(namespace); err == nil {
+			t.Fatal("Expected an invalid namespace: " + namespace)
+		}
+	}
+}
+
 func nodeContains(nodes []*Node, name string) bool {
 	for _, r := range nodes {
 		if r.name == name {
@@ -225,6 +366,49 @@ func createLocalGitCache(t *testing.T) *git.Cache {
 	return cache
 }
 
+// Creates a cache which uses the fake backend.
+func createFakeCache(t *testing.T, fake *git.FakeBackend) *git.Cache {
+	cache, err := git.NewCacheWithOptions(t.TempDir(), git.CacheOptions{Backend: fake})
+	if err != nil {
+		t.Fatal("Failed to create cache: " + err.Error())
+	}
+	return cache
+}
+
+// Creates the simple graph with fake remotes.
+// Returns the graph, the backend and the remotes by name.
+func createFakeGraph(t *testing.T) (*Graph, *git.FakeBackend, map[string]*git.FakeRemote) {
+	fake := git.NewFakeBackend()
+	remotes := make(map[string]*git.FakeRemote)
+	repos := []repo{
+		{
+			Name: "foo",
+			URL:  "https://fake/foo.git",
+			Deps: []string{"bar", "baz"},
+		},
+		{
+			Name: "bar",
+			URL:  "https://fake/bar.git",
+		},
+		{
+			Name: "baz",
+			URL:  "https://fake/baz.git",
+		},
+	}
+	for _, r := range repos {
+		remotes[r.Name] = fake.AddRemote(r.URL)
+	}
+	data, err := json.Marshal(repos)
+	if err != nil {
+		t.Fatal(err)
+	}
+	graph, err := NewGraph(createFakeCache(t, fake), data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return graph, fake, remotes
+}
+
 // Creates a git repo in a temp directory.
 func createLocalGitRepo(t *testing.T) string {
 	dir := t.TempDir()
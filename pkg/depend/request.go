@@ -1,6 +1,7 @@
 package depend
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 
@@ -15,6 +16,13 @@ type Request struct {
 	To     string `json:"To"`
 	Author string `json:"Author,omitempty"`
 	Email  string `json:"Email,omitempty"`
+	// Strategy overrides the strategy of the repository.
+	Strategy git.MergeStrategy `json:"Strategy,omitempty"`
+	// FromURL is the repository of the From branch, e.g. a fork.
+	// The branch is always merged into the repository of the node.
+	FromURL string `json:"FromUrl,omitempty"`
+	// Transaction is the ID of the merge, see git.WithTransaction.
+	Transaction string `json:"Transaction,omitempty"`
 }
 
 type RequestsTable map[*Node]*Request
@@ -68,46 +76,126 @@ func (requests *Requests) AddRequest(name string, from string, to string, author
 	return nil
 }
 
+// SetStrategy of the request for the named repository.
+func (requests *Requests) SetStrategy(name string, strategy git.MergeStrategy) error {
+	if err := strategy.Valid(); err != nil {
+		return err
+	}
+	node, ok := requests.nodesTable[name]
+	if !ok {
+		return errors.New("Node does not exist")
+	}
+	request, ok := requests.table[node]
+	if !ok {
+		return errors.New("Request does not exist")
+	}
+	request.Strategy = strategy
+	return nil
+}
+
+// SetFromURL of the request for the named repository, e.g. a fork.
+func (requests *Requests) SetFromURL(name string, url string) error {
+	node, ok := requests.nodesTable[name]
+	if !ok {
+		return errors.New("Node does not exist")
+	}
+	request, ok := requests.table[node]
+	if !ok {
+		return errors.New("Request does not exist")
+	}
+	request.FromURL = url
+	return nil
+}
+
+// owner of the locks which are taken for the request.
+func (request *Request) owner() string {
+	if request.Email == "" {
+		return request.Author
+	}
+	return request.Author + " <" + request.Email + ">"
+}
+
+// options for merging the request into the node.
+func (request *Request) options(node *Node) git.MergeOptions {
+	return git.MergeOptions{
+		Strategy: request.strategy(node),
+		FromURL:  request.FromURL,
+		// The dependencies of the branch take effect when it lands.
+		Notes: []string{node.depsRef},
+	}
+}
+
+// strategy returns the strategy of the request, then the repository, then the default.
+func (request *Request) strategy(node *Node) git.MergeStrategy {
+	if request.Strategy != "" {
+		return request.Strategy
+	}
+	if node.strategy != "" {
+		return node.strategy
+	}
+	return git.DefaultMergeStrategy
+}
+
 // Merge the requests.
-func (requests *Requests) Merge() error {
-	if err := requests.writeLocks(); err != nil {
+// Nothing is locked unless every branch merges cleanly, see Check.
+// If anything fails, the locks which were taken are released.
+func (requests *Requests) Merge(ctx context.Context) error {
+	if err := requests.preflight(ctx); err != nil {
+		return err
+	}
+
+	if err := requests.writeLocks(ctx); err != nil {
+		requests.removeLocks(ctx)
 		return err
 	}
 
 	for k, v := range requests.table {
+		v.Transaction = git.Transaction(ctx)
 		msg, err := v.String()
 		if err != nil {
+			requests.removeLocks(ctx)
 			return err
 		}
-		if err = requests.cache.Merge(k.url, v.From, v.To, msg); err != nil {
+		if err = requests.cache.Merge(ctx, k.url, v.From, v.To, msg, v.options(k)); err != nil {
+			requests.removeLocks(ctx)
 			return err
 		}
 	}
 
-	if err := requests.removeLocks(); err != nil {
+	if err := requests.removeLocks(ctx); err != nil {
 		return err
 	}
 	return nil
 }
 
 // writeLocks for a request and the children.
-func (requests *Requests) writeLocks() error {
+// A lock is recorded as soon as it is pushed, so that removeLocks releases it.
+// A lock is recorded as soon as it is pushed, so that removeLocks releases it.
+func (requests *Requests) writeLocks(ctx context.Context) error {
 	visited := utils.NewSet()
-	for node := range requests.table {
+	for node, request := range requests.table {
 		if !visited.Exists(node.name) {
 			lock := NewLock(node.name, requests.cache)
-			if err := lock.writeLock(node); err != nil {
+			lock.Owner = request.owner()
+			err := lock.writeLock(ctx, node)
+			if lock.held {
+				requests.lockTable[node] = lock
+			}
+			if err != nil {
 				return err
 			}
-			requests.lockTable[node] = lock
 			visited.Add(node.name)
 			for _, d := range node.Children() {
 				if !visited.Exists(d.name) {
 					lock := NewLock(d.name, requests.cache)
-					if err := lock.writeLock(d); err != nil {
+					lock.Owner = request.owner()
+					err := lock.writeLock(ctx, d)
+					if lock.held {
+						requests.lockTable[d] = lock
+					}
+					if err != nil {
 						return err
 					}
-					requests.lockTable[node] = lock
 					visited.Add(d.name)
 				}
 			}
@@ -116,13 +204,13 @@ func (requests *Requests) writeLocks() error {
 	return nil
 }
 
-func (requests *Requests) removeLocks() error {
+func (requests *Requests) removeLocks(ctx context.Context) error {
 	visited := utils.NewSet()
 	for node := range requests.table {
 		if !visited.Exists(node.name) {
 			lock, ok := requests.lockTable[node]
 			if ok {
-				if err := lock.removeLock(node); err != nil {
+				if err := lock.removeLock(ctx, node); err != nil {
 					return err
 				}
 			}
@@ -133,11 +221,11 @@ func (requests *Requests) removeLocks() error {
 			if !visited.Exists(d.name) {
 				lock, ok := requests.lockTable[d]
 				if ok {
-					if err := lock.removeLock(d); err != nil {
+					if err := lock.removeLock(ctx, d); err != nil {
 						return err
 					}
 				}
-				delete(requests.lockTable, node)
+				delete(requests.lockTable, d)
 				visited.Add(d.name)
 			}
 		}
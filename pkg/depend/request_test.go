@@ -1,8 +1,12 @@
 package depend
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"os"
 	"path"
+	"strings"
 	"testing"
 
 	"github.com/git-depend/git-depend/pkg/git"
@@ -47,7 +51,7 @@ func TestMergeRequests(t *testing.T) {
 	if err = requests.AddRequest("foo", staging_branch, "master", "Eric", "eric@email.com"); err != nil {
 		t.Fatal("Could not add request: " + err.Error())
 	}
-	if err = requests.Merge(); err != nil {
+	if err = requests.Merge(context.Background()); err != nil {
 		t.Fatal(err)
 	}
 
@@ -63,16 +67,454 @@ func writeBranchLocalGitRepo(git_path string, file_name string, branch string) e
 	}
 	file.Close()
 
-	if err = git.CheckoutNewBranch(git_path, branch); err != nil {
+	if err = git.CheckoutNewBranch(context.Background(), git_path, branch); err != nil {
 		return err
 	}
 
-	if err = git.Add(git_path, []string{file_name}); err != nil {
+	if err = git.Add(context.Background(), git_path, []string{file_name}); err != nil {
 		return err
 	}
 
-	if err = git.Commit(git_path, file_name); err != nil {
+	if err = git.Commit(context.Background(), git_path, file_name); err != nil {
 		return err
 	}
 	return nil
 }
+
+func TestMergeRequestsFake(t *testing.T) {
+	graph, fake, remotes := createFakeGraph(t)
+	remotes["foo"].Commit("feature")
+
+	requests := NewRequests(graph.table, createFakeCache(t, fake))
+	if err := requests.AddRequest("foo", "feature", "master", "Eric", "eric@email.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := requests.Merge(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// The initial commit, the rebased feature commit and the merge commit.
+	if n := len(remotes["foo"].Branch("master")); n != 3 {
+		t.Fatalf("Expected 3 commits on master: %d", n)
+	}
+	assertUnlocked(t, remotes)
+}
+
+func TestMergeRequestsLockHeld(t *testing.T) {
+	graph, fake, remotes := createFakeGraph(t)
+	remotes["foo"].Commit("feature")
+	remotes["bar"].AddNote(ref_lock_name, "", `{"Id":"other"}`)
+
+	requests := NewRequests(graph.table, createFakeCache(t, fake))
+	if err := requests.AddRequest("foo", "feature", "master", "Eric", "eric@email.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := requests.Merge(context.Background()); !errors.Is(err, git.ErrLockHeld) {
+		t.Fatal("Should not merge while another lock is held: ", err)
+	}
+
+	if n := len(remotes["foo"].Branch("master")); n != 1 {
+		t.Fatal("master should not have changed.")
+	}
+	if len(remotes["bar"].Notes(ref_lock_name)) != 1 {
+		t.Fatal("The other lock should still be held.")
+	}
+	delete(remotes, "bar")
+	assertUnlocked(t, remotes)
+}
+
+func TestMergeRequestsLockHeldElsewhere(t *testing.T) {
+	graph, fake, remotes := createFakeGraph(t)
+	remotes["foo"].Commit("feature")
+	// The other merge locked bar when its HEAD was an older commit.
+	remotes["bar"].AddNote(ref_lock_name, "", `{"Id":"other"}`)
+	remotes["bar"].Commit("master")
+
+	requests := NewRequests(graph.table, createFakeCache(t, fake))
+	if err := requests.AddRequest("foo", "feature", "master", "Eric", "eric@email.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := requests.Merge(context.Background()); !errors.Is(err, git.ErrLockHeld) {
+		t.Fatal("Should not merge while another lock is held: ", err)
+	}
+	if len(remotes["bar"].Notes(ref_lock_name)) != 1 {
+		t.Fatal("The other lock should be the only one.")
+	}
+	delete(remotes, "bar")
+	assertUnlocked(t, remotes)
+}
+
+// listAfterLock fails to list the lock notes once, after the first lock has been pushed.
+type listAfterLock struct {
+	git.Backend
+	pushed bool
+	failed bool
+}
+
+func (backend *listAfterLock) PushNotes(ctx context.Context, remote string, directory string, ref string) error {
+	err := backend.Backend.PushNotes(ctx, remote, directory, ref)
+	if err == nil && ref == ref_lock_name {
+		backend.pushed = true
+	}
+	return err
+}
+
+func (backend *listAfterLock) ListNotes(ctx context.Context, directory string, ref string) ([]byte, error) {
+	if backend.pushed && !backend.failed && ref == ref_lock_name {
+		backend.failed = true
+		return nil, git.ErrFakeNetwork
+	}
+	return backend.Backend.ListNotes(ctx, directory, ref)
+}
+
+func TestMergeRequestsLockPushedNotListed(t *testing.T) {
+	graph, fake, remotes := createFakeGraph(t)
+	remotes["foo"].Commit("feature")
+	cache, err := git.NewCacheWithOptions(t.TempDir(), git.CacheOptions{Backend: &listAfterLock{Backend: fake}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	requests := NewRequests(graph.table, cache)
+	if err := requests.AddRequest("foo", "feature", "master", "Eric", "eric@email.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := requests.Merge(context.Background()); !errors.Is(err, git.ErrNetwork) {
+		t.Fatal("Expected the network failure: ", err)
+	}
+	assertUnlocked(t, remotes)
+}
+
+func TestMergeRequestsFailures(t *testing.T) {
+	failures := map[string]struct {
+		fail func(*git.FakeBackend, map[string]*git.FakeRemote)
+		kind error
+	}{
+		"conflict": {func(fake *git.FakeBackend, remotes map[string]*git.FakeRemote) {
+			remotes["foo"].Conflict("feature")
+		}, git.ErrConflict},
+		"network": {func(fake *git.FakeBackend, remotes map[string]*git.FakeRemote) {
+			fake.FailOn("Push", "", git.ErrFakeNetwork)
+		}, git.ErrNetwork},
+		"rejected": {func(fake *git.FakeBackend, remotes map[string]*git.FakeRemote) {
+			fake.FailOn("Push", "", git.ErrFakeRejected)
+		}, git.ErrNonFastForward},
+	}
+	for name, failure := range failures {
+		failure := failure
+		t.Run(name, func(t *testing.T) {
+			graph, fake, remotes := createFakeGraph(t)
+			remotes["foo"].Commit("feature")
+			failure.fail(fake, remotes)
+
+			requests := NewRequests(graph.table, createFakeCache(t, fake))
+			if err := requests.AddRequest("foo", "feature", "master", "Eric", "eric@email.com"); err != nil {
+				t.Fatal(err)
+			}
+			if err := requests.Merge(context.Background()); !errors.Is(err, failure.kind) {
+				t.Fatalf("Expected the merge to fail with %v: %v", failure.kind, err)
+			}
+
+			if n := len(remotes["foo"].Branch("master")); n != 1 {
+				t.Fatal("master should not have changed.")
+			}
+			if len(requests.lockTable) != 0 {
+				t.Fatal("locks not released after a failed merge")
+			}
+			assertUnlocked(t, remotes)
+		})
+	}
+}
+
+func TestMergeRequestsDryRun(t *testing.T) {
+	graph, fake, remotes := createFakeGraph(t)
+	remotes["foo"].Commit("feature")
+
+	cache, err := git.NewCacheWithOptions(t.TempDir(), git.CacheOptions{Backend: fake, DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	requests := NewRequests(graph.table, cache)
+	if err := requests.AddRequest("foo", "feature", "master", "Eric", "eric@email.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := requests.Merge(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(remotes["foo"].Branch("master")); n != 1 {
+		t.Fatal("master should not have changed.")
+	}
+	assertUnlocked(t, remotes)
+
+	// Lock foo, bar and baz, push foo and then unlock them.
+	mutations := cache.Mutations()
+	if len(mutations) != 7 {
+		t.Fatalf("Expected 7 mutations: %v", mutations)
+	}
+	branch := mutations[3]
+	if branch.Ref != "refs/heads/master" || branch.Old != remotes["foo"].Branch("master")[0] {
+		t.Fatalf("Unexpected branch push: %v", branch)
+	}
+}
+
+func TestMergeRequestsStrategy(t *testing.T) {
+	graph, fake, remotes := createFakeGraph(t)
+	feature := remotes["foo"].Commit("feature")
+	graph.table["foo"].strategy = git.MergeFFOnlyNoMarker
+
+	requests := NewRequests(graph.table, createFakeCache(t, fake))
+	if err := requests.AddRequest("foo", "feature", "master", "Eric", "eric@email.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := requests.Merge(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// No marker commit, the metadata is a note on the tip.
+	master := remotes["foo"].Branch("master")
+	if len(master) != 2 || master[1] != feature {
+		t.Fatalf("master should be fast-forwarded to feature: %v", master)
+	}
+	if note := remotes["foo"].Notes(git.MergeNotesRef)[feature]; !strings.Contains(note, `"From":"feature"`) {
+		t.Fatalf("Expected the request in a note: %q", note)
+	}
+	assertUnlocked(t, remotes)
+}
+
+func TestMergeRequestsStrategyOverride(t *testing.T) {
+	graph, fake, remotes := createFakeGraph(t)
+	feature := remotes["foo"].Commit("feature")
+	graph.table["foo"].strategy = git.MergeFFOnlyNoMarker
+
+	requests := NewRequests(graph.table, createFakeCache(t, fake))
+	if err := requests.AddRequest("foo", "feature", "master", "Eric", "eric@email.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := requests.SetStrategy("foo", "octopus"); err == nil {
+		t.Fatal("Should not set an unknown strategy.")
+	}
+	if err := requests.SetStrategy("foo", git.MergeSquash); err != nil {
+		t.Fatal(err)
+	}
+	if err := requests.Merge(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// The initial commit and the squashed commit.
+	master := remotes["foo"].Branch("master")
+	if len(master) != 2 || master[1] == feature {
+		t.Fatalf("Expected a squashed commit on master: %v", master)
+	}
+	if notes := remotes["foo"].Notes(git.MergeNotesRef); len(notes) != 0 {
+		t.Fatalf("Squash should not add notes: %v", notes)
+	}
+}
+
+func TestMergeRequestsFromFork(t *testing.T) {
+	graph, fake, remotes := createFakeGraph(t)
+	fork := remotes["foo"].Fork("https://fake/alice/foo.git")
+	fork.Commit("feature")
+
+	requests := NewRequests(graph.table, createFakeCache(t, fake))
+	if err := requests.AddRequest("foo", "feature", "master", "Eric", "eric@email.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := requests.SetFromURL("foo", "https://fake/alice/foo.git"); err != nil {
+		t.Fatal(err)
+	}
+	if err := requests.Merge(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// The initial commit, the feature commit from the fork and the merge commit.
+	if n := len(remotes["foo"].Branch("master")); n != 3 {
+		t.Fatalf("Expected 3 commits on master: %d", n)
+	}
+	if n := len(fork.Branch("master")); n != 1 {
+		t.Fatal("Nothing should be pushed to the fork.")
+	}
+	assertUnlocked(t, remotes)
+}
+
+func TestCheckRequests(t *testing.T) {
+	graph, fake, remotes := createFakeGraph(t)
+	for _, name := range []string{"foo", "bar", "baz"} {
+		remotes[name].Commit("feature")
+	}
+	remotes["bar"].Conflict("feature")
+	// Taking a lock would fail, so this shows that none was attempted.
+	fake.FailOn("AddNotes", "", errors.New("locked before the check"))
+
+	requests := NewRequests(graph.table, createFakeCache(t, fake))
+	for _, name := range []string{"foo", "bar", "baz"} {
+		if err := requests.AddRequest(name, "feature", "master", "Eric", "eric@email.com"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	results := requests.Check(context.Background())
+	if len(results) != 3 || results[0].Name != "bar" || results[0].Clean() || !results[1].Clean() || !results[2].Clean() {
+		t.Fatalf("Only bar should conflict: %v", results)
+	}
+
+	err := requests.Merge(context.Background())
+	var conflict *ConflictError
+	if !errors.As(err, &conflict) || !errors.Is(err, git.ErrConflict) || len(conflict.Results) != 1 {
+		t.Fatal("Expected bar to conflict: ", err)
+	}
+	if n := len(remotes["foo"].Branch("master")); n != 1 {
+		t.Fatal("master should not have changed.")
+	}
+	assertUnlocked(t, remotes)
+}
+
+// assertUnlocked fails if any of the remotes still has a lock.
+func assertUnlocked(t *testing.T, remotes map[string]*git.FakeRemote) {
+	for name, remote := range remotes {
+		if notes := remote.Notes(ref_lock_name); len(notes) != 0 {
+			t.Fatalf("%s is still locked: %v", name, notes)
+		}
+	}
+}
+
+func TestMergeBranchDependencies(t *testing.T) {
+	data := createSimpleLocalGraph(t)
+	graph, err := NewGraph(nil, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeBranchLocalGitRepo(graph.table["foo"].url, "other.txt", "staging")
+	cache := createLocalGitCache(t)
+	if graph, err = NewGraph(cache, data); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	node := graph.table["foo"]
+	if err := node.PopulateDependencyNotes(ctx, "id", "master", cache); err != nil {
+		t.Fatal(err)
+	}
+	// The branch drops baz.
+	deps := node.deps
+	node.deps = deps[:1]
+	if err := node.PopulateDependencyNotes(ctx, "id", "staging", cache); err != nil {
+		t.Fatal(err)
+	}
+	node.deps = deps
+
+	countDeps := func(branch string) int {
+		data, err := graph.readDependencyNotes(ctx, node, branch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var repos []*repo
+		if err := json.Unmarshal(data, &repos); err != nil {
+			t.Fatal(err)
+		}
+		return len(repos)
+	}
+	if n := countDeps("master"); n != 2 {
+		t.Fatalf("Expected 2 dependencies on master: %d", n)
+	}
+	if n := countDeps("staging"); n != 1 {
+		t.Fatalf("Expected 1 dependency on staging: %d", n)
+	}
+
+	requests := graph.NewRequests()
+	if err = requests.AddRequest("foo", "staging", "master", "Eric", "eric@email.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err = requests.Merge(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if n := countDeps("master"); n != 1 {
+		t.Fatalf("Expected the dependencies of staging to land on master: %d", n)
+	}
+
+	// A branch without a note keeps the dependencies of master.
+	writeBranchLocalGitRepo(graph.table["foo"].url, "hotfix.txt", "hotfix")
+	if _, err := cache.CloneOrUpdate(ctx, graph.table["foo"].url); err != nil {
+		t.Fatal(err)
+	}
+	requests = graph.NewRequests()
+	if err = requests.AddRequest("foo", "hotfix", "master", "Eric", "eric@email.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err = requests.Merge(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if n := countDeps("master"); n != 1 {
+		t.Fatalf("Expected master to keep its dependencies: %d", n)
+	}
+
+	if _, err := graph.readDependencyNotes(ctx, graph.table["bar"], "master"); !errors.Is(err, git.ErrNoteMissing) {
+		t.Fatal("Expected no dependencies for bar: ", err)
+	}
+}
+
+func TestStatusRequests(t *testing.T) {
+	graph, fake, remotes := createFakeGraph(t)
+	remotes["foo"].Commit("feature")
+	commit := remotes["bar"].Commit("feature")
+	remotes["bar"].Commit("master")
+	remotes["bar"].Conflict("feature")
+	remotes["bar"].AddNote(ref_lock_name, "", `{"Id":"other","Owner":"Eve <eve@email.com>"}`)
+	remotes["bar"].AddNote(ref_deps_name, commit, `[{"Name":"qux","Url":"https://fake/qux.git"}]`)
+	if err := graph.table["foo"].PopulateDependencyNotes(context.Background(), "ID", "feature", graph.cache); err != nil {
+		t.Fatal(err)
+	}
+
+	requests := NewRequests(graph.table, createFakeCache(t, fake))
+	for _, name := range []string{"foo", "bar", "baz"} {
+		if err := requests.AddRequest(name, "feature", "master", "Eric", "eric@email.com"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	results := requests.Status(context.Background())
+	if len(results) != 3 {
+		t.Fatalf("Expected a status for each request: %v", results)
+	}
+	bar, baz, foo := results[0], results[1], results[2]
+	if foo.Err != nil || !foo.Exists || foo.Ahead != 1 || foo.Behind != 0 || foo.Deps != DepsCurrent || !foo.Mergeable() {
+		t.Fatalf("foo should be mergeable: %+v", foo)
+	}
+	if bar.Err != nil || !bar.Exists || bar.Ahead != 1 || bar.Behind != 1 || bar.Deps != DepsStale || bar.Mergeable() {
+		t.Fatalf("bar should not be mergeable: %+v", bar)
+	}
+	if bar.Lock == nil || bar.Lock.ID != "other" || bar.Lock.Owner != "Eve <eve@email.com>" || len(bar.Conflicts) == 0 {
+		t.Fatalf("bar should be locked by Eve and conflict: %+v", bar)
+	}
+	if baz.Err != nil || baz.Exists || baz.Mergeable() {
+		t.Fatalf("baz has no branch: %+v", baz)
+	}
+	if len(remotes["foo"].Notes(ref_lock_name)) != 0 {
+		t.Fatal("The status should not take any locks.")
+	}
+}
+
+func TestStatusFromFork(t *testing.T) {
+	graph, fake, remotes := createFakeGraph(t)
+	fork := remotes["foo"].Fork("https://fake/alice/foo.git")
+	commit := fork.Commit("feature")
+	// The upstream has its own feature branch, without a note.
+	remotes["foo"].Commit("feature")
+	data, err := json.Marshal(graph.table["foo"].directChildRepos())
+	if err != nil {
+		t.Fatal(err)
+	}
+	remotes["foo"].AddNote(ref_deps_name, commit, string(data))
+
+	requests := NewRequests(graph.table, createFakeCache(t, fake))
+	if err := requests.AddRequest("foo", "feature", "master", "Eric", "eric@email.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := requests.SetFromURL("foo", "https://fake/alice/foo.git"); err != nil {
+		t.Fatal(err)
+	}
+	results := requests.Status(context.Background())
+	if len(results) != 1 || results[0].Err != nil || results[0].Deps != DepsCurrent {
+		t.Fatalf("Expected the note of the fork's branch: %+v", results[0])
+	}
+}
@@ -0,0 +1,123 @@
+package depend
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+
+	"github.com/git-depend/git-depend/pkg/git"
+)
+
+// DepsState says whether the dependency note of a branch matches the graph.
+type DepsState string
+
+const (
+	DepsCurrent DepsState = "current"
+	DepsStale   DepsState = "stale"
+	DepsMissing DepsState = "missing"
+)
+
+// Status of the branch of a request, compared to its target.
+type Status struct {
+	Name string
+	From string
+	To   string
+	// Exists is false if the branch is not on the remote, and then nothing else is checked.
+	Exists bool
+	// Ahead is the number of commits on the branch which are not on the target.
+	Ahead int
+	// Behind is the number of commits on the target which are not on the branch.
+	Behind int
+	// Lock is the holder of the lock of the repository, or nil if it isn't locked.
+	Lock *Holder
+	// Deps compares the dependency note on the tip of the branch with the graph.
+	Deps DepsState
+	// Conflicts are the files which don't merge cleanly.
+	Conflicts []string
+	// Err is set if the status couldn't be found.
+	Err error
+}
+
+// Mergeable returns true if the branch exists, merges cleanly and the repository isn't locked.
+func (status *Status) Mergeable() bool {
+	return status.Err == nil && status.Exists && status.Lock == nil && len(status.Conflicts) == 0
+}
+
+// Status of every request, without taking any locks.
+// The results are sorted by name.
+func (requests *Requests) Status(ctx context.Context) []*Status {
+	results := make([]*Status, 0, len(requests.table))
+	for node, request := range requests.table {
+		results = append(results, requests.status(ctx, node, request))
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Name < results[j].Name
+	})
+	return results
+}
+
+func (requests *Requests) status(ctx context.Context, node *Node, request *Request) *Status {
+	status := &Status{
+		Name: node.name,
+		From: request.From,
+		To:   request.To,
+	}
+	options := request.options(node)
+	ahead, behind, err := requests.cache.AheadBehind(ctx, node.url, request.From, request.To, options)
+	if errors.Is(err, git.ErrRemoteRefMissing) {
+		return status
+	} else if err != nil {
+		status.Err = err
+		return status
+	}
+	status.Exists = true
+	status.Ahead = ahead
+	status.Behind = behind
+
+	if status.Lock, status.Err = readLock(ctx, requests.cache, node); status.Err != nil {
+		return status
+	}
+	start, err := requests.cache.FetchStart(ctx, node.url, request.From, options)
+	if err != nil {
+		status.Err = err
+		return status
+	}
+	if status.Deps, status.Err = requests.depsState(ctx, node, start); status.Err != nil {
+		return status
+	}
+	status.Conflicts, status.Err = requests.cache.CanMerge(ctx, node.url, request.From, request.To, options)
+	return status
+}
+
+// depsState compares the dependency note on the start, the tip of the branch, with the graph.
+// A branch from a fork has its note in the upstream's notes too.
+func (requests *Requests) depsState(ctx context.Context, node *Node, start string) (DepsState, error) {
+	if err := requests.cache.FetchNotes(ctx, node.url, node.depsRef); err != nil {
+		return "", err
+	}
+	data, err := requests.cache.ShowNotes(ctx, node.url, node.depsRef, start)
+	if errors.Is(err, git.ErrNoteMissing) {
+		return DepsMissing, nil
+	} else if err != nil {
+		return "", err
+	}
+	// The notes are compared once encoded the same way, as empty lists are left out.
+	var repos []*repo
+	if err := json.Unmarshal(data, &repos); err != nil {
+		return DepsStale, nil
+	}
+	found, err := json.Marshal(repos)
+	if err != nil {
+		return "", err
+	}
+	expected, err := json.Marshal(node.directChildRepos())
+	if err != nil {
+		return "", err
+	}
+	if !bytes.Equal(found, expected) {
+		return DepsStale, nil
+	}
+	return DepsCurrent, nil
+}
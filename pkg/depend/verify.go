@@ -0,0 +1,66 @@
+package depend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/git-depend/git-depend/pkg/git"
+)
+
+// ErrTransactionNotFound is returned when no repository has a commit of the transaction.
+var ErrTransactionNotFound = errors.New("transaction not found")
+
+// SignatureResult is the signature check of one commit of a transaction.
+type SignatureResult struct {
+	Name   string
+	Ref    string
+	Commit string
+	// Err is set if the commit is unsigned or the signature is bad.
+	Err error
+}
+
+// VerifyTransaction checks the signatures of the commits and notes of a transaction.
+// The commits are the ones on the branch whose message has the transaction ID,
+// and the notes commits which added or removed a note with it, or which were made by it, such as the copied notes.
+// Only commits since the time are searched, unless it is zero, as the whole history is slow to search.
+// The results are sorted by name.
+func (graph *Graph) VerifyTransaction(ctx context.Context, ID string, branch string, since time.Time) ([]*SignatureResult, error) {
+	notes := []string{ref_lock_name, depsNotesRef(graph.namespace), git.MergeNotesRef}
+	refs := []string{git.UpstreamBranchRef(branch)}
+	for _, ref := range notes {
+		refs = append(refs, "refs/notes/"+ref)
+	}
+
+	var results []*SignatureResult
+	for _, name := range graph.Names() {
+		node := graph.table[name]
+		if err := graph.cache.FetchBranches(ctx, node.url); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		for _, ref := range notes {
+			if err := graph.cache.FetchNotes(ctx, node.url, ref); err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+		}
+		for _, ref := range refs {
+			commits, err := graph.cache.FindCommits(ctx, node.url, ref, ID, since)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+			for _, commit := range commits {
+				results = append(results, &SignatureResult{
+					Name:   name,
+					Ref:    ref,
+					Commit: commit,
+					Err:    graph.cache.VerifyCommit(ctx, node.url, commit),
+				})
+			}
+		}
+	}
+	if len(results) == 0 {
+		return nil, fmt.Errorf("%s: %w", ID, ErrTransactionNotFound)
+	}
+	return results, nil
+}
@@ -0,0 +1,98 @@
+package depend
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/git-depend/git-depend/pkg/git"
+	"github.com/git-depend/git-depend/pkg/internal/testkey"
+)
+
+func TestVerifyTransaction(t *testing.T) {
+	for _, signed := range []bool{true, false} {
+		options := git.CacheOptions{}
+		if signed {
+			options.Signing = createSigningKey(t)
+		}
+		cache, err := git.NewCacheWithOptions(t.TempDir(), options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data := createSimpleLocalGraph(t)
+		graph, err := NewGraph(cache, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		writeBranchLocalGitRepo(graph.table["foo"].url, "other.txt", "staging")
+		// The merge copies the note of the branch, without the ID in it.
+		if err := graph.WriteDependencyNotes(context.Background(), graph.table["foo"], "notes", "staging"); err != nil {
+			t.Fatal(err)
+		}
+		// Another cache which cloned the repositories before the merge.
+		stale, err := git.NewCacheWithOptions(t.TempDir(), options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		staleGraph, err := NewGraph(stale, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := stale.CloneOrUpdateMany(context.Background(), staleGraph.URLs()); err != nil {
+			t.Fatal(err)
+		}
+
+		ctx := git.WithTransaction(context.Background(), "0123abcd")
+		requests := graph.NewRequests()
+		if err = requests.AddRequest("foo", "staging", "master", "Eric", "eric@email.com"); err != nil {
+			t.Fatal(err)
+		}
+		if err = requests.Merge(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		since := time.Now().Add(-time.Hour)
+		results, err := staleGraph.VerifyTransaction(ctx, "0123abcd", "master", since)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The marker commit, the copied note, and the lock being added and removed in each repository.
+		if len(results) != 8 {
+			t.Fatalf("Expected 8 commits: %v", results)
+		}
+		copied := false
+		for _, result := range results {
+			if result.Name == "foo" && result.Ref == "refs/notes/"+depsNotesRef(graph.namespace) {
+				copied = true
+			}
+		}
+		if !copied {
+			t.Fatalf("Expected the copied note: %v", results)
+		}
+		for _, result := range results {
+			if signed && result.Err != nil {
+				t.Fatalf("%s %s should be signed: %v", result.Name, result.Ref, result.Err)
+			} else if !signed && result.Err == nil {
+				t.Fatalf("%s %s should not be signed.", result.Name, result.Ref)
+			}
+		}
+
+		if _, err := graph.VerifyTransaction(ctx, "fedcba98", "master", since); !errors.Is(err, ErrTransactionNotFound) {
+			t.Fatal("Should not find another transaction: ", err)
+		}
+	}
+}
+
+// Creates an SSH signing key which is trusted for any principal.
+// Skips the test if ssh-keygen is not installed.
+func createSigningKey(t *testing.T) git.Signing {
+	key, allowed, err := testkey.New(t.TempDir())
+	if errors.Is(err, exec.ErrNotFound) {
+		t.Skip("ssh-keygen is not installed")
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	return git.Signing{Format: git.SigningSSH, Key: key, AllowedSigners: allowed}
+}
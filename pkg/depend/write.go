@@ -1,6 +1,8 @@
 package depend
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -24,8 +26,33 @@ type lockref struct {
 type lock struct {
 	ID        string    `json:"Id"`
 	Timestamp time.Time `json:"Timestamp"`
-	cache     *git.Cache
-	lockref   lockref
+	// Transaction is the ID of the merge which holds the lock, see git.WithTransaction.
+	Transaction string `json:"Transaction,omitempty"`
+	// Owner is the author of the merge, as "Name <email>".
+	Owner   string `json:"Owner,omitempty"`
+	cache   *git.Cache
+	lockref lockref
+	// held is set once the lock has been pushed, even if writeLock then fails.
+	held bool
+}
+
+// LockedError is returned when another merge holds the lock of a repository.
+// errors.Is(err, git.ErrLockHeld) is true for it.
+type LockedError struct {
+	Name string
+	Err  error
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("%s is locked by another merge: %v", e.Name, e.Err)
+}
+
+func (e *LockedError) Unwrap() error {
+	return e.Err
+}
+
+func (e *LockedError) Is(target error) bool {
+	return target == git.ErrLockHeld
 }
 
 func NewLock(ID string, cache *git.Cache) *lock {
@@ -37,41 +64,97 @@ func NewLock(ID string, cache *git.Cache) *lock {
 }
 
 // writeLock will lock an individual repository.
-func (lock *lock) writeLock(node *Node) error {
+func (lock *lock) writeLock(ctx context.Context, node *Node) error {
+	lock.Transaction = git.Transaction(ctx)
 	data, err := json.Marshal(lock)
 	if err != nil {
 		return err
 	}
 
-	if err = lock.cache.AddNotes(node.url, ref_lock_name, string(data)); err != nil {
+	// Make sure that we see any lock which is already held.
+	if err = lock.cache.FetchNotes(ctx, node.url, ref_lock_name); err != nil {
+		return err
+	}
+	// The lock is on HEAD, which differs between clones, so it may be on another object.
+	notes, err := lock.cache.ListNotes(ctx, node.url, ref_lock_name)
+	if err != nil {
 		return err
 	}
+	if len(bytes.TrimSpace(notes)) > 0 {
+		return &LockedError{node.name, git.ErrNoteExists}
+	}
 
-	if err = lock.cache.PushNotes(node.url, ref_lock_name); err != nil {
+	if err = lock.cache.AddNotes(ctx, node.url, ref_lock_name, string(data)); err != nil {
+		if errors.Is(err, git.ErrNoteExists) {
+			return &LockedError{node.name, err}
+		}
 		return err
 	}
 
-	if err = lock.populateLockRef(node); err != nil {
+	if err = lock.cache.PushNotes(ctx, node.url, ref_lock_name); err != nil {
+		// Don't keep a lock which was never pushed.
+		lock.cache.ResetNotes(ctx, node.url, ref_lock_name)
+		if errors.Is(err, git.ErrNonFastForward) {
+			// Somebody else took the lock first.
+			return &LockedError{node.name, err}
+		}
+		return err
+	}
+	lock.held = true
+
+	if err = lock.populateLockRef(ctx, node); err != nil {
 		return err
 	}
 	return nil
 }
 
-func (lock *lock) removeLock(node *Node) error {
-	if err := lock.populateLockRef(node); err != nil {
+func (lock *lock) removeLock(ctx context.Context, node *Node) error {
+	if err := lock.populateLockRef(ctx, node); err != nil {
 		return err
 	}
-	if err := lock.cache.RemoveNotes(node.url, ref_lock_name, lock.lockref.object); err != nil {
+	if err := lock.cache.RemoveNotes(ctx, node.url, ref_lock_name, lock.lockref.object); err != nil {
 		return err
 	}
-	if err := lock.cache.PushNotes(node.url, ref_lock_name); err != nil {
+	if err := lock.cache.PushNotes(ctx, node.url, ref_lock_name); err != nil {
 		return err
 	}
 	return nil
 }
 
-func (lock *lock) populateLockRef(node *Node) error {
-	byte_s, err := lock.cache.ListNotes(node.url, ref_lock_name)
+// Holder of the lock of a repository.
+type Holder struct {
+	ID          string    `json:"Id"`
+	Timestamp   time.Time `json:"Timestamp"`
+	Transaction string    `json:"Transaction,omitempty"`
+	Owner       string    `json:"Owner,omitempty"`
+}
+
+// readLock returns the holder of the lock of the repository, or nil if it isn't locked.
+func readLock(ctx context.Context, cache *git.Cache, node *Node) (*Holder, error) {
+	if err := cache.FetchNotes(ctx, node.url, ref_lock_name); err != nil {
+		return nil, err
+	}
+	byte_s, err := cache.ListNotes(ctx, node.url, ref_lock_name)
+	if err != nil || len(bytes.TrimSpace(byte_s)) == 0 {
+		return nil, err
+	}
+	_, object, err := parseUniqueNote(byte_s)
+	if err != nil || object == "" {
+		return nil, err
+	}
+	byte_s, err = cache.ShowNotes(ctx, node.url, ref_lock_name, object)
+	if err != nil {
+		return nil, err
+	}
+	holder := &Holder{}
+	if err := json.Unmarshal(byte_s, holder); err != nil {
+		return nil, err
+	}
+	return holder, nil
+}
+
+func (lock *lock) populateLockRef(ctx context.Context, node *Node) error {
+	byte_s, err := lock.cache.ListNotes(ctx, node.url, ref_lock_name)
 	if err != nil {
 		return err
 	}
@@ -1,6 +1,7 @@
 package depend
 
 import (
+	"context"
 	"testing"
 
 	"github.com/git-depend/git-depend/pkg/git"
@@ -20,11 +21,11 @@ func TestWriteRequests(t *testing.T) {
 	if err = requests.AddRequest("foo", "branch", "main", "Eric", "eric@email.com"); err != nil {
 		t.Fatal("Could not add request: " + err.Error())
 	}
-	if err = requests.writeLocks(); err != nil {
+	if err = requests.writeLocks(context.Background()); err != nil {
 		t.Fatal("Could not write requests: " + err.Error())
 	}
 
-	_, err = cache.ShowNotes(urls[0], ref_lock_name, "")
+	_, err = cache.ShowNotes(context.Background(), urls[0], ref_lock_name, "")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -36,7 +37,7 @@ func TestWriteRequests(t *testing.T) {
 		t.Fatal("lockref git object reference not updated")
 	}
 
-	if err = requests.removeLocks(); err != nil {
+	if err = requests.removeLocks(context.Background()); err != nil {
 		t.Fatal("Could not remove locks: " + err.Error())
 	}
 	_, ok := requests.lockTable[requests.nodesTable["foo"]]
@@ -53,12 +54,12 @@ func TestWriteFailedRequests(t *testing.T) {
 	}
 	urls := graph.URLs()
 	cache := createLocalGitCache(t)
-	if _, err = cache.CloneOrUpdateMany(urls); err != nil {
+	if _, err = cache.CloneOrUpdateMany(context.Background(), urls); err != nil {
 		t.Fatal(err)
 	}
 
 	node := graph.table["foo"]
-	if err = git.AddNotes(node.url, ref_lock_name, "Some note"); err != nil {
+	if err = git.AddNotes(context.Background(), node.url, ref_lock_name, "Some note"); err != nil {
 		t.Fatal(err)
 	}
 
@@ -67,7 +68,7 @@ func TestWriteFailedRequests(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if err = requests.writeLocks(); err == nil {
+	if err = requests.writeLocks(context.Background()); err == nil {
 		t.Fatal("Should have failed to create lock.")
 	}
 	_, ok := requests.lockTable[requests.nodesTable["foo"]]
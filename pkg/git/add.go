@@ -1,7 +1,9 @@
 package git
 
-func Add(directory string, files []string) error {
+import "context"
+
+func Add(ctx context.Context, directory string, files []string) error {
 	args := append([]string{"add"}, files...)
-	_, err := execute(directory, args)
+	_, err := execute(ctx, directory, args)
 	return err
 }
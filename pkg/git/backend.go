@@ -0,0 +1,171 @@
+package git
+
+import (
+	"context"
+	"time"
+)
+
+// Backend performs the git operations for the cache.
+// The directory is the path of the clone inside the cache.
+type Backend interface {
+	Clone(ctx context.Context, url string, directory string, strategy CloneStrategy) error
+	FetchBranches(ctx context.Context, directory string, remote string) error
+	// FetchBranch fetches a branch from another URL, e.g. a fork, into refs/remotes/{remote}/{branch}.
+	FetchBranch(ctx context.Context, directory string, remote string, url string, branch string) error
+	// FetchHistory makes sure that a and b have a common ancestor in a shallow clone.
+	FetchHistory(ctx context.Context, directory string, remote string, a string, b string) error
+
+	FetchNotes(ctx context.Context, directory string, remote string, ref string, strategy NotesMergeStrategy) error
+	ResetNotes(ctx context.Context, directory string, remote string, ref string) error
+	AddNotes(ctx context.Context, directory string, ref string, note string) error
+	AppendNotes(ctx context.Context, directory string, ref string, note string) error
+	// SetNotes of the object, replacing any note which it already has.
+	SetNotes(ctx context.Context, directory string, ref string, object string, note string) error
+	ListNotes(ctx context.Context, directory string, ref string) ([]byte, error)
+	ShowNotes(ctx context.Context, directory string, ref string, object string) ([]byte, error)
+	RemoveNotes(ctx context.Context, directory string, ref string, object string) error
+	PushNotes(ctx context.Context, remote string, directory string, ref string) error
+	// SignNotes signs the tip of the notes ref, if the context has signing, and records the transaction of the context in it.
+	// A tip which came from the remote is left alone.
+	SignNotes(ctx context.Context, directory string, remote string, ref string) error
+
+	Checkout(ctx context.Context, directory string, branch string) error
+	// CheckoutAt checks out the branch, creating it or resetting it to start.
+	CheckoutAt(ctx context.Context, directory string, branch string, start string) error
+	Rebase(ctx context.Context, directory string, onto string) error
+	Merge(ctx context.Context, directory string, branch string) error
+	MergeCommit(ctx context.Context, directory string, branch string, message string) error
+	SquashMerge(ctx context.Context, directory string, branch string, message string) error
+	EmptyCommit(ctx context.Context, directory string, message string) error
+	// CanMerge returns the files which conflict when from is merged into to, without touching the worktree.
+	CanMerge(ctx context.Context, directory string, from string, to string) ([]string, error)
+	// AheadBehind returns the number of commits on from which are not on to, and the other way around.
+	AheadBehind(ctx context.Context, directory string, from string, to string) (int, int, error)
+	Push(ctx context.Context, remote string, directory string, branch string) error
+	VerifyCommit(ctx context.Context, directory string, revision string) error
+	// FindCommits returns the commits reachable from the revision which mention the text, see FindCommits.
+	FindCommits(ctx context.Context, directory string, revision string, text string, since time.Time) ([]string, error)
+	// ResolveRef returns the SHA of a full ref name, or an empty string if it doesn't exist.
+	ResolveRef(ctx context.Context, directory string, ref string) (string, error)
+	// Inspect the clone for Cache.Verify.
+	// Returns an error if the clone is too broken for its origin to be read.
+	Inspect(ctx context.Context, directory string) (RepositoryState, error)
+}
+
+// ExecBackend runs the git binary.
+// This is the default backend.
+type ExecBackend struct{}
+
+var _ Backend = ExecBackend{}
+
+func (ExecBackend) Clone(ctx context.Context, url string, directory string, strategy CloneStrategy) error {
+	return CloneWithStrategy(ctx, url, directory, strategy)
+}
+
+func (ExecBackend) FetchBranches(ctx context.Context, directory string, remote string) error {
+	return FetchBranches(ctx, directory, remote)
+}
+
+func (ExecBackend) FetchBranch(ctx context.Context, directory string, remote string, url string, branch string) error {
+	return FetchBranch(ctx, directory, remote, url, branch)
+}
+
+func (ExecBackend) FetchHistory(ctx context.Context, directory string, remote string, a string, b string) error {
+	return ensureMergeBase(ctx, directory, remote, a, b)
+}
+
+func (ExecBackend) FetchNotes(ctx context.Context, directory string, remote string, ref string, strategy NotesMergeStrategy) error {
+	return FetchNotes(ctx, directory, remote, ref, strategy)
+}
+
+func (ExecBackend) ResetNotes(ctx context.Context, directory string, remote string, ref string) error {
+	return ResetNotes(ctx, directory, remote, ref)
+}
+
+func (ExecBackend) AddNotes(ctx context.Context, directory string, ref string, note string) error {
+	return AddNotes(ctx, directory, ref, note)
+}
+
+func (ExecBackend) AppendNotes(ctx context.Context, directory string, ref string, note string) error {
+	return AppendNotes(ctx, directory, ref, note)
+}
+
+func (ExecBackend) SetNotes(ctx context.Context, directory string, ref string, object string, note string) error {
+	return SetNotes(ctx, directory, ref, object, note)
+}
+
+func (ExecBackend) ListNotes(ctx context.Context, directory string, ref string) ([]byte, error) {
+	return ListNotes(ctx, directory, ref)
+}
+
+func (ExecBackend) ShowNotes(ctx context.Context, directory string, ref string, object string) ([]byte, error) {
+	return ShowNotes(ctx, directory, ref, object)
+}
+
+func (ExecBackend) RemoveNotes(ctx context.Context, directory string, ref string, object string) error {
+	return RemoveNotes(ctx, directory, ref, object)
+}
+
+func (ExecBackend) PushNotes(ctx context.Context, remote string, directory string, ref string) error {
+	return PushNotes(ctx, remote, directory, ref)
+}
+
+func (ExecBackend) Checkout(ctx context.Context, directory string, branch string) error {
+	return Checkout(ctx, directory, branch)
+}
+
+func (ExecBackend) CheckoutAt(ctx context.Context, directory string, branch string, start string) error {
+	return CheckoutAt(ctx, directory, branch, start)
+}
+
+func (ExecBackend) Rebase(ctx context.Context, directory string, onto string) error {
+	return Rebase(ctx, directory, onto)
+}
+
+func (ExecBackend) Merge(ctx context.Context, directory string, branch string) error {
+	return Merge(ctx, directory, branch)
+}
+
+func (ExecBackend) MergeCommit(ctx context.Context, directory string, branch string, message string) error {
+	return MergeCommit(ctx, directory, branch, message)
+}
+
+func (ExecBackend) SquashMerge(ctx context.Context, directory string, branch string, message string) error {
+	return SquashMerge(ctx, directory, branch, message)
+}
+
+func (ExecBackend) CanMerge(ctx context.Context, directory string, from string, to string) ([]string, error) {
+	return CanMerge(ctx, directory, from, to)
+}
+
+func (ExecBackend) AheadBehind(ctx context.Context, directory string, from string, to string) (int, int, error) {
+	return AheadBehind(ctx, directory, from, to)
+}
+
+func (ExecBackend) SignNotes(ctx context.Context, directory string, remote string, ref string) error {
+	return SignNotes(ctx, directory, remote, ref)
+}
+
+func (ExecBackend) VerifyCommit(ctx context.Context, directory string, revision string) error {
+	return VerifyCommit(ctx, directory, revision)
+}
+
+func (ExecBackend) Inspect(ctx context.Context, directory string) (RepositoryState, error) {
+	return InspectRepository(ctx, directory)
+}
+
+func (ExecBackend) FindCommits(ctx context.Context, directory string, revision string, text string, since time.Time) ([]string, error) {
+	return FindCommits(ctx, directory, revision, text, since)
+}
+
+func (ExecBackend) EmptyCommit(ctx context.Context, directory string, message string) error {
+	return EmptyCommit(ctx, directory, message)
+}
+
+func (ExecBackend) Push(ctx context.Context, remote string, directory string, branch string) error {
+	return Push(ctx, remote, directory, branch)
+}
+
+func (ExecBackend) ResolveRef(ctx context.Context, directory string, ref string) (string, error) {
+	return resolveRef(ctx, directory, ref)
+}
@@ -1,15 +1,19 @@
 package git
 
 import (
+	"context"
 	"crypto/sha1"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"hash"
 	"os"
 	"path"
+	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
-
-	"github.com/git-depend/git-depend/pkg/utils"
+	"time"
 )
 
 // Stores the path of the cache
@@ -17,132 +21,317 @@ type Cache struct {
 	sync.Mutex
 	// root path to the cache
 	root string
-	// repositories maps the URL to the filepath
+	// repositories maps the normalised URL to the filepath
 	repositories map[string]string
+	options      CacheOptions
+	// hosts limits the number of git processes talking to each host.
+	hosts   map[string]chan struct{}
+	backend Backend
+	// mutations which a dry run would have pushed.
+	mutations []Mutation
+	// leases maps the URL and ref to what a dry run has pretended to push.
+	leases map[string]string
+}
+
+// CacheOptions configures the behaviour of the cache.
+type CacheOptions struct {
+	// URLRules declare which URLs are equivalent.
+	URLRules URLRules
+	// MaxWorkers is the number of repositories updated at once by CloneOrUpdateMany.
+	// Defaults to DefaultMaxWorkers.
+	MaxWorkers int
+	// MaxPerHost is the number of clones or fetches allowed at once for a single host.
+	// Defaults to DefaultMaxPerHost.
+	MaxPerHost int
+	// CloneStrategies maps a URL to the way it should be cloned.
+	// URLs which are not in the map are cloned in full.
+	CloneStrategies map[string]CloneStrategy
+	// NotesMergeStrategy resolves local notes which have diverged from the remote.
+	// Defaults to DefaultNotesMergeStrategy.
+	NotesMergeStrategy NotesMergeStrategy
+	// Offline works entirely from the existing cache.
+	// Nothing is fetched and anything which would change a remote returns ErrOffline.
+	Offline bool
+	// Backend runs the git operations.
+	// Defaults to ExecBackend.
+	Backend Backend
+	// Credentials maps a URL to the credentials for its remote.
+	Credentials map[string]Credentials
+	// Remotes maps the URL of a fork to the name of its remote in the clones.
+	// Other forks are named after the hash of their URL.
+	Remotes map[string]string
+	// Signing signs the merge commits and the notes commits.
+	Signing Signing
+	// DryRun records what would be pushed instead of pushing it.
+	// Everything else still happens in the cache, so it should be a scratch cache.
+	DryRun bool
 }
 
+// upstream is the name of the remote which the cache cloned.
+// Everything is pushed to it.
+const upstream = "origin"
+
+// ErrOffline is returned when the cache is offline and an operation needs the network.
+var ErrOffline = errors.New("not available offline")
+
+const (
+	DefaultMaxWorkers = 8
+	DefaultMaxPerHost = 4
+	// The remote is the source of truth for notes.
+	DefaultNotesMergeStrategy = NotesMergeTheirs
+	// Number of commits fetched at a time when a shallow repository needs more history.
+	DefaultDeepenStep = 50
+	// Number of times a shallow repository is deepened before fetching everything.
+	maxDeepenAttempts = 4
+)
+
+// CacheError lists the failures by URL.
 type CacheError struct {
 	Errors map[string]error
 }
 
 func (e *CacheError) Error() string {
+	urls := make([]string, 0, len(e.Errors))
+	for k := range e.Errors {
+		urls = append(urls, k)
+	}
+	sort.Strings(urls)
 	msg := ""
-	for k, v := range e.Errors {
-		msg += fmt.Sprintf("URL: %s\nError: %s\n\n", k, v)
+	for _, k := range urls {
+		msg += fmt.Sprintf("URL: %s\nError: %s\n\n", k, e.Errors[k])
 	}
 	return msg
 }
 
+// NewCache with the default options.
 func NewCache(path string) (*Cache, error) {
+	return NewCacheWithOptions(path, CacheOptions{})
+}
+
+// NewCacheWithOptions creates the cache directory if it doesn't exist.
+func NewCacheWithOptions(path string, options CacheOptions) (*Cache, error) {
 	_, err := os.Stat(path)
 	if os.IsNotExist(err) {
 		if err := os.MkdirAll(path, 0755); err != nil {
 			return nil, err
 		}
 	}
+	if options.MaxWorkers <= 0 {
+		options.MaxWorkers = DefaultMaxWorkers
+	}
+	if options.MaxPerHost <= 0 {
+		options.MaxPerHost = DefaultMaxPerHost
+	}
+	if options.NotesMergeStrategy == "" {
+		options.NotesMergeStrategy = DefaultNotesMergeStrategy
+	}
+	if options.Backend == nil {
+		options.Backend = ExecBackend{}
+	}
 	return &Cache{
 		root:         path,
 		repositories: make(map[string]string),
+		options:      options,
+		hosts:        make(map[string]chan struct{}),
+		backend:      options.Backend,
+		leases:       make(map[string]string),
 	}, nil
 }
 
+// NormaliseURL returns the canonical form of the URL using the cache's rules.
+// Equivalent URLs share the same cache entry.
+func (cache *Cache) NormaliseURL(url string) string {
+	return cache.options.URLRules.Normalise(url)
+}
+
+// cloneStrategy for a normalised URL.
+func (cache *Cache) cloneStrategy(key string) CloneStrategy {
+	for url, strategy := range cache.options.CloneStrategies {
+		if cache.NormaliseURL(url) == key {
+			return strategy
+		}
+	}
+	return CloneStrategy{Mode: CloneFull}
+}
+
 func (cache *Cache) getNewHasher() hash.Hash {
 	return sha1.New()
 }
 
+// acquireHost blocks until there is a free slot for the host of the url.
+// Returns a function which releases the slot.
+func (cache *Cache) acquireHost(ctx context.Context, key string) (func(), error) {
+	host := "local"
+	if !strings.HasPrefix(key, "file://") && !filepath.IsAbs(key) && !path.IsAbs(key) {
+		host = strings.SplitN(key, "/", 2)[0]
+	}
+
+	cache.Lock()
+	slots, ok := cache.hosts[host]
+	if !ok {
+		slots = make(chan struct{}, cache.options.MaxPerHost)
+		cache.hosts[host] = slots
+	}
+	cache.Unlock()
+
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// CloneResult is the outcome of cloning or updating a single repository.
+type CloneResult struct {
+	URL string
+	// SHA is the shasum of the normalised URL, which names the directory.
+	SHA       string
+	Directory string
+	// Cloned is true if the repository was cloned rather than fetched.
+	Cloned   bool
+	Duration time.Duration
+	Err      error
+}
+
 // CloneOrUpdate figures out if we need to clone the repo or simply update.
-// Clones are done by taking the shasum of the url and using that as the directory name.
+// Clones are done by taking the shasum of the normalised url and using that as the directory name.
 // Returns the sha and error.
-func (cache *Cache) CloneOrUpdate(url string) (string, error) {
-	h := cache.getNewHasher()
-	h.Write([]byte(url))
-	sha := hex.EncodeToString(h.Sum(nil))
+// The number of git processes per host is limited by MaxPerHost.
+func (cache *Cache) CloneOrUpdate(ctx context.Context, url string) (string, error) {
+	result := cache.cloneOrUpdate(ctx, url)
+	if result.Err != nil {
+		return "", result.Err
+	}
+	return result.SHA, nil
+}
+
+func (cache *Cache) cloneOrUpdate(ctx context.Context, url string) *CloneResult {
+	start := time.Now()
+	key := cache.NormaliseURL(url)
+	sha := cache.hashKey(key)
+
+	result := &CloneResult{
+		URL:       url,
+		SHA:       sha,
+		Directory: path.Join(cache.root, sha),
+	}
+	result.Err = cache.cloneOrFetch(ctx, url, key, result)
+	result.Duration = time.Since(start)
+	if result.Err != nil {
+		return result
+	}
+
+	cache.Lock()
+	cache.repositories[key] = sha
+	cache.Unlock()
+
+	return result
+}
+
+// cloneOrFetch populates the result's directory.
+func (cache *Cache) cloneOrFetch(ctx context.Context, url string, key string, result *CloneResult) error {
+	ctx = cache.withCredentials(ctx, url)
+	release, err := cache.acquireHost(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer release()
 
-	directory := path.Join(cache.root, sha)
-	_, err := os.Stat(directory)
+	directory := result.Directory
+	_, err = os.Stat(directory)
 	// If directory exists, add it to the map.
 	// If not, create it.
-	if os.IsNotExist(err) {
+	if os.IsNotExist(err) && cache.options.Offline {
+		return fmt.Errorf("%s is not in the cache: %w", url, ErrOffline)
+	} else if os.IsNotExist(err) {
+		result.Cloned = true
 		// Create a tmp directory incase something goes wrong.
-		tmp_directory := path.Join(cache.root, "tmp", sha)
+		tmp_directory := path.Join(cache.root, "tmp", result.SHA)
 		if err := os.RemoveAll(tmp_directory); err != nil {
-			return "", err
+			return err
 		}
 		if err := os.MkdirAll(tmp_directory, 0755); err != nil {
-			return "", err
-		}
-		if err := Clone(url, tmp_directory); err != nil {
-			return "", err
-		}
-		// We have to execute a fetch as clone doesn't download notes.
-		if err := Fetch(tmp_directory); err != nil {
-			return "", err
+			return err
 		}
-		if err := os.Rename(tmp_directory, directory); err != nil {
-			return "", err
+		if err := cache.backend.Clone(ctx, url, tmp_directory, cache.cloneStrategy(key)); err != nil {
+			return err
 		}
+		return os.Rename(tmp_directory, directory)
 	} else if err != nil {
-		return "", err
-	} else if err := Fetch(directory); err != nil {
-		return "", err
+		return err
+	} else if cache.options.Offline {
+		return nil
 	}
-
-	cache.Lock()
-	cache.repositories[url] = sha
-	cache.Unlock()
-
-	return sha, nil
+	return cache.backend.FetchBranches(ctx, directory, upstream)
 }
 
-// CloneOrUpdateMany repositories.
-// Returns the first error encountered.
-func (cache *Cache) CloneOrUpdateMany(urls []string) ([]string, error) {
+// CloneOrUpdateMany repositories using a pool of MaxWorkers.
+// Cancelling the context stops the pool and kills any running git processes.
+// Returns a result for each URL, in the same order as the URLs.
+// Equivalent URLs are only cloned or fetched once and share a result.
+// If any failed, the error is a *CacheError listing the failures by URL.
+func (cache *Cache) CloneOrUpdateMany(ctx context.Context, urls []string) ([]*CloneResult, error) {
 	var wg sync.WaitGroup
-	shas_chan := make(chan struct {
-		string
-		error
-	}, len(urls))
-	set := utils.NewSet()
-
-	for _, url := range urls {
-		if exists := set.Exists(url); !exists {
-			set.Add(url)
-			wg.Add(1)
-			go func(url string) {
-				defer wg.Done()
-				s, e := cache.CloneOrUpdate(url)
-				shas_chan <- struct {
-					string
-					error
-				}{s, e}
-			}(url)
+	jobs := make(chan int)
+	results := make([]*CloneResult, len(urls))
+
+	for i := 0; i < cache.options.MaxWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = cache.cloneOrUpdate(ctx, urls[i])
+			}
+		}()
+	}
+
+	// Maps the normalised URL to the index of the first URL.
+	first := make(map[string]int, len(urls))
+	for i, url := range urls {
+		key := cache.NormaliseURL(url)
+		if _, ok := first[key]; ok {
+			continue
+		}
+		first[key] = i
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			results[i] = &CloneResult{URL: url, Err: ctx.Err()}
 		}
 	}
+	close(jobs)
 	wg.Wait()
-	close(shas_chan)
 
-	shas := make([]string, len(urls))
-	errors := make(map[string]error, len(urls))
-	i := 0
-	for sha := range shas_chan {
-		if sha.error != nil {
-			errors[sha.string] = sha.error
+	errors := make(map[string]error)
+	for i, url := range urls {
+		if results[i] == nil {
+			result := *results[first[cache.NormaliseURL(url)]]
+			result.URL = url
+			results[i] = &result
+		}
+		if results[i].Err != nil {
+			errors[url] = results[i].Err
 		}
-		shas[i] = sha.string
-		i++
+	}
+
+	if err := ctx.Err(); err != nil {
+		return results, err
 	}
 	if len(errors) > 0 {
-		return nil, &CacheError{errors}
+		return results, &CacheError{errors}
 	}
-	return shas, nil
+	return results, nil
 }
 
 // GetRepositoryDirectory returns the directory if it exists.
 // If it doesn't exist, it performs a CloneOrUpdate().
-func (cache *Cache) GetRepositoryDirectory(url string) (string, error) {
-	repo, ok := cache.repositories[url]
+func (cache *Cache) GetRepositoryDirectory(ctx context.Context, url string) (string, error) {
+	cache.Lock()
+	repo, ok := cache.repositories[cache.NormaliseURL(url)]
+	cache.Unlock()
 	if !ok {
-		sha, err := cache.CloneOrUpdate(url)
+		sha, err := cache.CloneOrUpdate(ctx, url)
 		if err != nil {
 			return "", err
 		}
@@ -151,7 +340,25 @@ func (cache *Cache) GetRepositoryDirectory(url string) (string, error) {
 	return path.Join(cache.root, repo), nil
 }
 
-// GetRepositories returns a list of the URLs.
+// RemoveRepository deletes the clone of the URL from the cache.
+// Returns the directory of the clone, which may not have existed.
+func (cache *Cache) RemoveRepository(url string) (string, error) {
+	key := cache.NormaliseURL(url)
+	dir := path.Join(cache.root, cache.hashKey(key))
+	cache.Lock()
+	delete(cache.repositories, key)
+	cache.Unlock()
+	return dir, os.RemoveAll(dir)
+}
+
+// hashKey is the name of the directory of the clone of the normalised URL.
+func (cache *Cache) hashKey(key string) string {
+	h := cache.getNewHasher()
+	h.Write([]byte(key))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// GetRepositories returns a list of the normalised URLs.
 func (cache *Cache) GetRepositories() []string {
 	keys := make([]string, len(cache.repositories))
 	i := 0
@@ -163,92 +370,393 @@ func (cache *Cache) GetRepositories() []string {
 }
 
 // AddNotes to HEAD in the repository.
-func (cache *Cache) AddNotes(url string, ref string, note string) error {
-	dir, err := cache.GetRepositoryDirectory(url)
+func (cache *Cache) AddNotes(ctx context.Context, url string, ref string, note string) error {
+	dir, err := cache.GetRepositoryDirectory(ctx, url)
 	if err != nil {
 		return err
 	}
-	return AddNotes(dir, ref, note)
+	if err = cache.backend.AddNotes(ctx, dir, ref, note); err != nil {
+		return err
+	}
+	return cache.signNotes(ctx, dir, ref)
 }
 
 // AppendNotes to HEAD in the repository.
-func (cache *Cache) AppendNotes(url string, ref string, note string) error {
-	dir, err := cache.GetRepositoryDirectory(url)
+func (cache *Cache) AppendNotes(ctx context.Context, url string, ref string, note string) error {
+	dir, err := cache.GetRepositoryDirectory(ctx, url)
 	if err != nil {
 		return err
 	}
-	return AppendNotes(dir, ref, note)
+	if err = cache.backend.AppendNotes(ctx, dir, ref, note); err != nil {
+		return err
+	}
+	return cache.signNotes(ctx, dir, ref)
 }
 
 // ListNotes in HEAD in the repository.
 // Returns the stdout if no error.
-func (cache *Cache) ListNotes(url string, ref string) ([]byte, error) {
-	dir, err := cache.GetRepositoryDirectory(url)
+func (cache *Cache) ListNotes(ctx context.Context, url string, ref string) ([]byte, error) {
+	dir, err := cache.GetRepositoryDirectory(ctx, url)
 	if err != nil {
 		return nil, err
 	}
-	return ListNotes(dir, ref)
+	return cache.backend.ListNotes(ctx, dir, ref)
 }
 
 // ShowNotes of an object.
 // If an empty object is given, defaults to HEAD.
 // Returns the stdout if there is no error.
-func (cache *Cache) ShowNotes(url string, ref string, object string) ([]byte, error) {
-	dir, err := cache.GetRepositoryDirectory(url)
+func (cache *Cache) ShowNotes(ctx context.Context, url string, ref string, object string) ([]byte, error) {
+	dir, err := cache.GetRepositoryDirectory(ctx, url)
 	if err != nil {
 		return nil, err
 	}
-	return ShowNotes(dir, ref, object)
+	return cache.backend.ShowNotes(ctx, dir, ref, object)
+}
+
+// FetchNotes from the remote, merging them if they have diverged.
+// Offline, the notes already in the cache are used.
+func (cache *Cache) FetchNotes(ctx context.Context, url string, ref string) error {
+	dir, err := cache.GetRepositoryDirectory(ctx, url)
+	if err != nil || cache.options.Offline {
+		return err
+	}
+	ctx = cache.withCredentials(ctx, url)
+	if err = cache.backend.FetchNotes(ctx, dir, upstream, ref, cache.options.NotesMergeStrategy); err != nil {
+		return err
+	}
+	// Diverged notes are merged with a new commit.
+	return cache.signNotes(ctx, dir, ref)
+}
+
+// FetchBranches updates the branches of the upstream in the clone, e.g. before they are searched.
+// Nothing is fetched if the cache is offline.
+func (cache *Cache) FetchBranches(ctx context.Context, url string) error {
+	dir, err := cache.GetRepositoryDirectory(ctx, url)
+	if err != nil || cache.options.Offline {
+		return err
+	}
+	return cache.backend.FetchBranches(cache.withCredentials(ctx, url), dir, upstream)
+}
+
+// UpstreamBranchRef is the full name of the tracking ref of a branch of the upstream.
+func UpstreamBranchRef(branch string) string {
+	return "refs/remotes/" + upstream + "/" + branch
+}
+
+// Offline returns true if the cache never uses the network.
+func (cache *Cache) Offline() bool {
+	return cache.options.Offline
+}
+
+// ResetNotes discards any local notes which have not been pushed.
+func (cache *Cache) ResetNotes(ctx context.Context, url string, ref string) error {
+	dir, err := cache.GetRepositoryDirectory(ctx, url)
+	if err != nil {
+		return err
+	}
+	return cache.backend.ResetNotes(ctx, dir, upstream, ref)
 }
 
-func (cache *Cache) PushNotes(url string, ref string) error {
-	dir, err := cache.GetRepositoryDirectory(url)
+// PushNotes to origin.
+// Returns ErrOffline if the cache is offline.
+func (cache *Cache) PushNotes(ctx context.Context, url string, ref string) error {
+	if cache.options.Offline {
+		return ErrOffline
+	}
+	dir, err := cache.GetRepositoryDirectory(ctx, url)
 	if err != nil {
 		return err
 	}
-	return PushNotes("origin", dir, ref)
+	if cache.options.DryRun {
+		return cache.recordPush(ctx, url, dir, "refs/notes/"+ref, remoteNotesRef(upstream, ref))
+	}
+	ctx = cache.withCredentials(ctx, url)
+	return cache.backend.PushNotes(ctx, upstream, dir, ref)
 }
 
 // RemoveNotes from the repository.
-func (cache *Cache) RemoveNotes(url string, ref string, object string) error {
-	dir, err := cache.GetRepositoryDirectory(url)
+func (cache *Cache) RemoveNotes(ctx context.Context, url string, ref string, object string) error {
+	dir, err := cache.GetRepositoryDirectory(ctx, url)
 	if err != nil {
 		return err
 	}
-	return RemoveNotes(dir, ref, object)
+	if err = cache.backend.RemoveNotes(ctx, dir, ref, object); err != nil {
+		return err
+	}
+	return cache.signNotes(ctx, dir, ref)
 }
 
-// Merge will perform a rebase and merge with --ff-only to keep a clean history and push.
-// It will also create an empty merge commit.
-func (cache *Cache) Merge(url string, from string, to string, msg string) error {
-	dir, err := cache.GetRepositoryDirectory(url)
+// SetNotes of the object in the repository, replacing any note which it already has.
+func (cache *Cache) SetNotes(ctx context.Context, url string, ref string, object string, note string) error {
+	dir, err := cache.GetRepositoryDirectory(ctx, url)
 	if err != nil {
 		return err
 	}
-
-	if err = Checkout(dir, from); err != nil {
+	if err = cache.backend.SetNotes(ctx, dir, ref, object, note); err != nil {
 		return err
 	}
+	return cache.signNotes(ctx, dir, ref)
+}
 
-	if err = Rebase(dir, "origin/"+to); err != nil {
+// Merge lands the branch with the strategy and pushes to the upstream.
+// The msg is the transaction metadata, carried in whichever commit the strategy makes.
+// The commits are signed if CacheOptions.Signing is set.
+// With MergeFFOnlyNoMarker there is no new commit, so msg is a note on the new tip in MergeNotesRef.
+// The notes of MergeOptions.Notes on the tip of the branch, or else on the tip of to, are copied to the new tip.
+// Returns ErrOffline if the cache is offline.
+func (cache *Cache) Merge(ctx context.Context, url string, from string, to string, msg string, options MergeOptions) error {
+	if cache.options.Offline {
+		return ErrOffline
+	}
+	strategy := options.Strategy
+	if err := strategy.Valid(); err != nil {
+		return err
+	}
+	if strategy == "" {
+		strategy = DefaultMergeStrategy
+	}
+	dir, err := cache.GetRepositoryDirectory(ctx, url)
+	if err != nil {
+		return err
+	}
+	start, err := cache.fetchFrom(ctx, dir, url, from, options.FromURL)
+	if err != nil {
 		return err
 	}
+	ctx = cache.withSigning(cache.withCredentials(ctx, url))
 
-	if err = Checkout(dir, to); err != nil {
+	if err = cache.backend.CheckoutAt(ctx, dir, from, start); err != nil {
 		return err
 	}
 
-	if err = Merge(dir, from); err != nil {
+	if strategy == MergeRebaseFF {
+		// A shallow clone may not have enough history to rebase.
+		if err = cache.backend.FetchHistory(ctx, dir, upstream, from, upstream+"/"+to); err != nil {
+			return err
+		}
+
+		if err = cache.backend.Rebase(ctx, dir, upstream+"/"+to); err != nil {
+			return err
+		}
+	}
+
+	// Reset to the upstream, as the local branch may be behind it,
+	// or still have the commits of an earlier merge which wasn't pushed.
+	if err = cache.backend.CheckoutAt(ctx, dir, to, upstream+"/"+to); err != nil {
 		return err
 	}
 
-	if err = EmptyCommit(dir, msg); err != nil {
+	switch strategy {
+	case MergeRebaseFF:
+		if err = cache.backend.Merge(ctx, dir, from); err != nil {
+			return err
+		}
+		err = cache.backend.EmptyCommit(ctx, dir, msg)
+	case MergeNoFF:
+		if err = cache.backend.FetchHistory(ctx, dir, upstream, from, upstream+"/"+to); err != nil {
+			return err
+		}
+		err = cache.backend.MergeCommit(ctx, dir, from, msg)
+	case MergeSquash:
+		if err = cache.backend.FetchHistory(ctx, dir, upstream, from, upstream+"/"+to); err != nil {
+			return err
+		}
+		err = cache.backend.SquashMerge(ctx, dir, from, msg)
+	case MergeFFOnlyNoMarker:
+		if err = cache.backend.Merge(ctx, dir, from); err != nil {
+			return err
+		}
+		if err = cache.backend.FetchNotes(ctx, dir, upstream, MergeNotesRef, cache.options.NotesMergeStrategy); err != nil {
+			return err
+		}
+		if err = cache.backend.AppendNotes(ctx, dir, MergeNotesRef, msg); err != nil {
+			return err
+		}
+		err = cache.signNotes(ctx, dir, MergeNotesRef)
+	}
+	if err != nil {
 		return err
 	}
 
-	if err = Push("origin", dir, to); err != nil {
+	var notes []string
+	if strategy == MergeFFOnlyNoMarker {
+		notes = append(notes, MergeNotesRef)
+	}
+	for _, ref := range options.Notes {
+		// A branch without its own note keeps the note of the target.
+		copied, err := cache.copyNotes(ctx, dir, ref, "HEAD", start, upstream+"/"+to)
+		if err != nil {
+			return err
+		}
+		if copied {
+			notes = append(notes, ref)
+		}
+	}
+
+	if cache.options.DryRun {
+		if err = cache.recordPush(ctx, url, dir, "refs/heads/"+to, "refs/remotes/origin/"+to); err != nil {
+			return err
+		}
+		for _, ref := range notes {
+			if err = cache.recordPush(ctx, url, dir, "refs/notes/"+ref, remoteNotesRef(upstream, ref)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err = cache.backend.Push(ctx, upstream, dir, to); err != nil {
 		return err
 	}
+	for _, ref := range notes {
+		if err = cache.backend.PushNotes(ctx, upstream, dir, ref); err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
+
+// copyNotes copies the note of the first object in from which has one to another object,
+// as rebasing or merging leaves it behind.
+// Returns false if there is nothing to copy, or the other object already has the same note.
+func (cache *Cache) copyNotes(ctx context.Context, dir string, ref string, to string, from ...string) (bool, error) {
+	if err := cache.backend.FetchNotes(ctx, dir, upstream, ref, cache.options.NotesMergeStrategy); err != nil {
+		return false, err
+	}
+	var note []byte
+	for _, object := range from {
+		found, err := cache.backend.ShowNotes(ctx, dir, ref, object)
+		if errors.Is(err, ErrNoteMissing) {
+			continue
+		} else if err != nil {
+			return false, err
+		}
+		note = found
+		break
+	}
+	if note == nil {
+		return false, nil
+	}
+	if existing, err := cache.backend.ShowNotes(ctx, dir, ref, to); err == nil && string(existing) == string(note) {
+		return false, nil
+	}
+	if err := cache.backend.SetNotes(ctx, dir, ref, to, string(note)); err != nil {
+		return false, err
+	}
+	return true, cache.signNotes(ctx, dir, ref)
+}
+
+// CanMerge returns the files which conflict when from is merged into to on the upstream.
+// No files means that it merges cleanly.
+// Only the FromURL of the options is used.
+// Nothing is checked out, so this can run before any lock is taken.
+func (cache *Cache) CanMerge(ctx context.Context, url string, from string, to string, options MergeOptions) ([]string, error) {
+	dir, err := cache.GetRepositoryDirectory(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	start, err := cache.fetchFrom(ctx, dir, url, from, options.FromURL)
+	if err != nil {
+		return nil, err
+	}
+	ctx = cache.withCredentials(ctx, url)
+	if !cache.options.Offline {
+		// A shallow clone may not have the merge base.
+		if err = cache.backend.FetchHistory(ctx, dir, upstream, start, upstream+"/"+to); err != nil {
+			return nil, err
+		}
+	}
+	return cache.backend.CanMerge(ctx, dir, start, upstream+"/"+to)
+}
+
+// AheadBehind returns the number of commits on from which are not on to on the upstream,
+// and the other way around.
+// Only the FromURL of the options is used.
+// Returns ErrRemoteRefMissing if from doesn't exist.
+func (cache *Cache) AheadBehind(ctx context.Context, url string, from string, to string, options MergeOptions) (int, int, error) {
+	dir, err := cache.GetRepositoryDirectory(ctx, url)
+	if err != nil {
+		return 0, 0, err
+	}
+	start, err := cache.fetchFrom(ctx, dir, url, from, options.FromURL)
+	if err != nil {
+		return 0, 0, err
+	}
+	if sha, err := cache.backend.ResolveRef(ctx, dir, "refs/remotes/"+start); err != nil {
+		return 0, 0, err
+	} else if sha == "" {
+		return 0, 0, fmt.Errorf("%s: %w", start, ErrRemoteRefMissing)
+	}
+	ctx = cache.withCredentials(ctx, url)
+	if !cache.options.Offline {
+		// A shallow clone can't count past the merge base.
+		if err = cache.backend.FetchHistory(ctx, dir, upstream, start, upstream+"/"+to); err != nil {
+			return 0, 0, err
+		}
+	}
+	return cache.backend.AheadBehind(ctx, dir, start, upstream+"/"+to)
+}
+
+// fetchFrom returns the remote tracking branch to merge from.
+// A branch in another repository, e.g. a fork, is fetched into its own remote first.
+// Offline, the branch is used as it was last fetched.
+func (cache *Cache) fetchFrom(ctx context.Context, dir string, url string, from string, fromURL string) (string, error) {
+	if fromURL == "" || cache.NormaliseURL(fromURL) == cache.NormaliseURL(url) {
+		return upstream + "/" + from, nil
+	}
+	remote := cache.remoteName(fromURL)
+	if !cache.options.Offline {
+		ctx = cache.withCredentials(ctx, fromURL)
+		if err := cache.backend.FetchBranch(ctx, dir, remote, fromURL, from); err != nil {
+			return "", err
+		}
+	}
+	return remote + "/" + from, nil
+}
+
+// FetchStart fetches the branch which would be merged and returns the revision of its tip,
+// i.e. origin/{from}, or the tracking branch of the fork of MergeOptions.FromURL.
+func (cache *Cache) FetchStart(ctx context.Context, url string, from string, options MergeOptions) (string, error) {
+	dir, err := cache.GetRepositoryDirectory(ctx, url)
+	if err != nil {
+		return "", err
+	}
+	return cache.fetchFrom(ctx, dir, url, from, options.FromURL)
+}
+
+// remoteName of a fork, from CacheOptions.Remotes or the hash of its URL.
+func (cache *Cache) remoteName(url string) string {
+	key := cache.NormaliseURL(url)
+	for u, name := range cache.options.Remotes {
+		if cache.NormaliseURL(u) == key && name != "" && name != upstream {
+			return name
+		}
+	}
+	h := cache.getNewHasher()
+	h.Write([]byte(key))
+	return "fork-" + hex.EncodeToString(h.Sum(nil))[:12]
+}
+
+// ensureMergeBase deepens a shallow repository until a and b have a common ancestor.
+// If they still don't, the rest of the history is fetched.
+func ensureMergeBase(ctx context.Context, dir string, remote string, a string, b string) error {
+	shallow, err := IsShallow(ctx, dir)
+	if err != nil || !shallow {
+		return err
+	}
+	depth := DefaultDeepenStep
+	for i := 0; i < maxDeepenAttempts; i++ {
+		if _, err := MergeBase(ctx, dir, a, b); err == nil {
+			return nil
+		}
+		if err := Deepen(ctx, dir, remote, depth); err != nil {
+			return err
+		}
+		depth *= 2
+	}
+	if _, err := MergeBase(ctx, dir, a, b); err == nil {
+		return nil
+	}
+	return Unshallow(ctx, dir, remote)
+}
@@ -1,14 +1,18 @@
 package git
 
 import (
+	"context"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"path"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestCacheDir(t *testing.T) {
@@ -34,7 +38,7 @@ func TestCacheKey(t *testing.T) {
 	tempDir := t.TempDir()
 	cache, _ := NewCache(tempDir)
 
-	if _, err := cache.CloneOrUpdate(urlA); err != nil {
+	if _, err := cache.CloneOrUpdate(context.Background(), urlA); err != nil {
 		t.Fatal("Error: ", err)
 	}
 
@@ -46,7 +50,7 @@ func TestCacheKey(t *testing.T) {
 		t.Fatal("Cache size wrong.")
 	}
 
-	if _, err := cache.CloneOrUpdate(urlB); err != nil {
+	if _, err := cache.CloneOrUpdate(context.Background(), urlB); err != nil {
 		t.Fatal("Error: ", err)
 	}
 
@@ -58,7 +62,7 @@ func TestCacheKey(t *testing.T) {
 		t.Fatal("Cache size wrong.")
 	}
 
-	if _, err := cache.CloneOrUpdate(urlA); err != nil {
+	if _, err := cache.CloneOrUpdate(context.Background(), urlA); err != nil {
 		t.Fatal("Error: ", err)
 	}
 
@@ -71,13 +75,34 @@ func TestCacheKey(t *testing.T) {
 	}
 }
 
+func TestCacheKeyEquivalentURLs(t *testing.T) {
+	urlA := createLocalGitRepo(t)
+	cache := createLocalGitCache(t)
+
+	shaA, err := cache.CloneOrUpdate(context.Background(), urlA)
+	if err != nil {
+		t.Fatal("Error: ", err)
+	}
+	shaB, err := cache.CloneOrUpdate(context.Background(), urlA+"/")
+	if err != nil {
+		t.Fatal("Error: ", err)
+	}
+
+	if shaA != shaB {
+		t.Fatalf("Equivalent URLs have different keys: %s != %s", shaA, shaB)
+	}
+	if len(cache.repositories) != 1 {
+		t.Fatal("Cache size wrong.")
+	}
+}
+
 func TestCacheCreateDirectory(t *testing.T) {
 	urlA := createLocalGitRepo(t)
 
 	tempDir := t.TempDir()
 	cache, _ := NewCache(tempDir)
 
-	if _, err := cache.CloneOrUpdate(urlA); err != nil {
+	if _, err := cache.CloneOrUpdate(context.Background(), urlA); err != nil {
 		t.Fatal("Error: ", err)
 	}
 
@@ -100,9 +125,9 @@ func TestCacheDirectoryAlreadyExists(t *testing.T) {
 	folder := path.Join(tempDir, sha)
 
 	// Create the directory with clone
-	Clone(urlA, folder)
+	Clone(context.Background(), urlA, folder)
 
-	if _, err := cache.CloneOrUpdate(urlA); err != nil {
+	if _, err := cache.CloneOrUpdate(context.Background(), urlA); err != nil {
 		t.Fatal("Error: ", err)
 	}
 
@@ -128,20 +153,260 @@ func TestCloneUpdateMany(t *testing.T) {
 
 		// Run
 		t.Run(strconv.Itoa(n_url), func(t *testing.T) {
-			shas, err := cache.CloneOrUpdateMany(urls)
+			results, err := cache.CloneOrUpdateMany(context.Background(), urls)
 			if err != nil {
 				t.Fatal("Error: ", err)
 			}
 
-			if len(shas) != n_url {
-				fmt.Println("Number of shas: ", n_urls)
+			if len(results) != n_url {
+				fmt.Println("Number of results: ", len(results))
 				fmt.Println("Expected: ", len(urls))
-				t.Fatal("Incorrect number of shas returned.")
+				t.Fatal("Incorrect number of results returned.")
+			}
+			for i, result := range results {
+				if result.URL != urls[i] || result.SHA == "" || !result.Cloned {
+					t.Fatalf("Incorrect result for %s: %+v", urls[i], result)
+				}
 			}
 		})
 	}
 }
 
+func TestCloneUpdateManyResults(t *testing.T) {
+	missing := "file://" + path.Join(t.TempDir(), "missing")
+	urls := []string{createLocalGitRepo(t), missing, createLocalGitRepo(t)}
+	cache := createLocalGitCache(t)
+
+	results, err := cache.CloneOrUpdateMany(context.Background(), urls)
+	cacheErr, ok := err.(*CacheError)
+	if !ok {
+		t.Fatal("Expected a CacheError: ", err)
+	}
+	if len(cacheErr.Errors) != 1 || cacheErr.Errors[missing] == nil {
+		t.Fatal("Failure not reported by URL: ", cacheErr)
+	}
+	if len(results) != len(urls) {
+		t.Fatal("Incorrect number of results returned.")
+	}
+	if results[1].Err == nil || results[0].Err != nil || results[2].Err != nil {
+		t.Fatal("Errors reported for the wrong URLs.")
+	}
+
+	// Update the repositories which were cloned.
+	urls = append(urls[:1], urls[2], urls[0]+"/")
+	results, err = cache.CloneOrUpdateMany(context.Background(), urls)
+	if err != nil {
+		t.Fatal("Error: ", err)
+	}
+	for _, result := range results {
+		if result.Cloned {
+			t.Fatal("Expected a fetch: ", result.URL)
+		}
+	}
+	if results[0].Directory != results[2].Directory || results[2].URL != urls[2] {
+		t.Fatal("Equivalent URLs do not share a result.")
+	}
+}
+
+func TestCloneUpdateManyBounded(t *testing.T) {
+	fake := NewFakeBackend()
+	var urls []string
+	for _, host := range []string{"alpha", "beta"} {
+		for i := 0; i < 6; i++ {
+			url := fmt.Sprintf("https://%s/repo%d.git", host, i)
+			fake.AddRemote(url)
+			urls = append(urls, url)
+		}
+	}
+	backend := &concurrencyBackend{Backend: fake, hosts: make(map[string]int), peakHosts: make(map[string]int)}
+
+	cache, err := NewCacheWithOptions(t.TempDir(), CacheOptions{MaxWorkers: 3, MaxPerHost: 2, Backend: backend})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cache.CloneOrUpdateMany(context.Background(), urls); err != nil {
+		t.Fatal("Error: ", err)
+	}
+	if len(cache.GetRepositories()) != len(urls) {
+		t.Fatal("Incorrect number of repositories.")
+	}
+	if backend.peak > 3 {
+		t.Fatalf("Expected at most 3 clones at once: %d", backend.peak)
+	}
+	for host, peak := range backend.peakHosts {
+		if peak > 2 {
+			t.Fatalf("Expected at most 2 clones of %s at once: %d", host, peak)
+		}
+	}
+}
+
+// concurrencyBackend records the most clones which ran at once, in total and per host.
+type concurrencyBackend struct {
+	Backend
+	sync.Mutex
+	running   int
+	peak      int
+	hosts     map[string]int
+	peakHosts map[string]int
+}
+
+func (backend *concurrencyBackend) Clone(ctx context.Context, url string, directory string, strategy CloneStrategy) error {
+	host := strings.SplitN(strings.TrimPrefix(url, "https://"), "/", 2)[0]
+	backend.Lock()
+	backend.running++
+	backend.hosts[host]++
+	if backend.running > backend.peak {
+		backend.peak = backend.running
+	}
+	if backend.hosts[host] > backend.peakHosts[host] {
+		backend.peakHosts[host] = backend.hosts[host]
+	}
+	backend.Unlock()
+
+	// Give the other workers time to start.
+	time.Sleep(20 * time.Millisecond)
+
+	backend.Lock()
+	backend.running--
+	backend.hosts[host]--
+	backend.Unlock()
+	return backend.Backend.Clone(ctx, url, directory, strategy)
+}
+
+func TestCloneUpdateManyCancelled(t *testing.T) {
+	urls := []string{createLocalGitRepo(t), createLocalGitRepo(t)}
+	cache := createLocalGitCache(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	results, err := cache.CloneOrUpdateMany(ctx, urls)
+	if err != context.Canceled {
+		t.Fatal("Expected the context to be cancelled: ", err)
+	}
+	for _, result := range results {
+		if result.Err == nil {
+			t.Fatal("Expected an error for ", result.URL)
+		}
+	}
+}
+
+func TestCloneShallowDeepen(t *testing.T) {
+	url := createLocalGitRepo(t)
+	dir := strings.TrimPrefix(url, "file://")
+	runGit(t, dir, "checkout", "-b", "feature")
+	runGit(t, dir, "commit", "--allow-empty", "-m", "Feature.")
+	runGit(t, dir, "checkout", "-")
+	for i := 0; i < 3; i++ {
+		runGit(t, dir, "commit", "--allow-empty", "-m", "Main.")
+	}
+
+	cache, err := NewCacheWithOptions(t.TempDir(), CacheOptions{
+		CloneStrategies: map[string]CloneStrategy{
+			url: {Mode: CloneShallow, Depth: 1},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := cache.CloneOrUpdateMany(context.Background(), []string{url})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clone := results[0].Directory
+	ctx := context.Background()
+
+	if shallow, err := IsShallow(ctx, clone); err != nil || !shallow {
+		t.Fatal("Expected a shallow clone: ", err)
+	}
+	if _, err := MergeBase(ctx, clone, "origin/feature", "origin/master"); err == nil {
+		t.Fatal("Expected the merge base to be missing.")
+	}
+	if err := ensureMergeBase(ctx, clone, "origin", "origin/feature", "origin/master"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := MergeBase(ctx, clone, "origin/feature", "origin/master"); err != nil {
+		t.Fatal("Expected the history to be deepened: ", err)
+	}
+}
+
+func TestCacheDryRun(t *testing.T) {
+	url := createLocalGitRepo(t)
+	ctx := context.Background()
+	cache, err := NewCacheWithOptions(t.TempDir(), CacheOptions{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cache.AddNotes(ctx, url, "test-lock", "lock"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.PushNotes(ctx, url, "test-lock"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.RemoveNotes(ctx, url, "test-lock", "HEAD"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.PushNotes(ctx, url, "test-lock"); err != nil {
+		t.Fatal(err)
+	}
+
+	if sha, err := resolveRef(ctx, strings.TrimPrefix(url, "file://"), "refs/notes/test-lock"); err != nil || sha != "" {
+		t.Fatal("A dry run should not push.", err)
+	}
+
+	mutations := cache.Mutations()
+	if len(mutations) != 2 {
+		t.Fatalf("Expected 2 mutations: %v", mutations)
+	}
+	if mutations[0].Ref != "refs/notes/test-lock" || mutations[0].Old != "" || mutations[0].New == "" {
+		t.Fatalf("Unexpected mutation: %v", mutations[0])
+	}
+	// The second push is leased on the first.
+	if mutations[1].Old != mutations[0].New || mutations[1].New == mutations[0].New {
+		t.Fatalf("Unexpected mutation: %v", mutations[1])
+	}
+}
+
+func TestCacheOffline(t *testing.T) {
+	url := createLocalGitRepo(t)
+	missing := createLocalGitRepo(t)
+	tempDir := t.TempDir()
+	ctx := context.Background()
+
+	online, _ := NewCache(tempDir)
+	if _, err := online.CloneOrUpdate(ctx, url); err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, strings.TrimPrefix(url, "file://"), "commit", "--allow-empty", "-m", "Second.")
+
+	offline, err := NewCacheWithOptions(tempDir, CacheOptions{Offline: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := offline.GetRepositoryDirectory(context.Background(), url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := execute(context.Background(), dir, []string{"rev-list", "--count", "origin/master"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(string(out)) != "1" {
+		t.Fatal("Should not have fetched while offline.")
+	}
+
+	if _, err := offline.CloneOrUpdate(ctx, missing); !errors.Is(err, ErrOffline) {
+		t.Fatal("Should not clone while offline: ", err)
+	}
+	if err := offline.AddNotes(context.Background(), url, "test-lock", "note"); err != nil {
+		t.Fatal(err)
+	}
+	if err := offline.PushNotes(context.Background(), url, "test-lock"); err != ErrOffline {
+		t.Fatal("Should not push while offline: ", err)
+	}
+}
+
 func TestGetRepositoryDirectory(t *testing.T) {
 	urlA := createLocalGitRepo(t)
 	tempDir := t.TempDir()
@@ -152,7 +417,7 @@ func TestGetRepositoryDirectory(t *testing.T) {
 	sha := hex.EncodeToString(h.Sum(nil))
 	folder := path.Join(tempDir, sha)
 
-	dir, err := cache.GetRepositoryDirectory(urlA)
+	dir, err := cache.GetRepositoryDirectory(context.Background(), urlA)
 
 	if err != nil {
 		t.Fatal("Error: ", err)
@@ -174,9 +439,9 @@ func TestGetRepositoryDirectoryAlreadyExists(t *testing.T) {
 	sha := hex.EncodeToString(h.Sum(nil))
 	folder := path.Join(tempDir, sha)
 
-	Clone(urlA, folder)
+	Clone(context.Background(), urlA, folder)
 
-	dir, err := cache.GetRepositoryDirectory(urlA)
+	dir, err := cache.GetRepositoryDirectory(context.Background(), urlA)
 
 	if err != nil {
 		t.Fatal("Error: ", err)
@@ -188,6 +453,33 @@ func TestGetRepositoryDirectoryAlreadyExists(t *testing.T) {
 	}
 }
 
+func TestRemoveRepository(t *testing.T) {
+	urlA := createLocalGitRepo(t)
+	cache, _ := NewCache(t.TempDir())
+	dir, err := cache.GetRepositoryDirectory(context.Background(), urlA)
+	if err != nil {
+		t.Fatal("Error: ", err)
+	}
+
+	removed, err := cache.RemoveRepository(urlA)
+	if err != nil {
+		t.Fatal("Error: ", err)
+	}
+	if removed != dir {
+		t.Fatalf("%s != %s", removed, dir)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Fatal("The clone should have been deleted: ", err)
+	}
+	if len(cache.GetRepositories()) != 0 {
+		t.Fatal("The repository should have been forgotten.")
+	}
+	// Removing it again is fine.
+	if _, err := cache.RemoveRepository(urlA); err != nil {
+		t.Fatal("Error: ", err)
+	}
+}
+
 func TestGetRepositories(t *testing.T) {
 	n_urls := []int{1, 3, 100}
 
@@ -203,7 +495,7 @@ func TestGetRepositories(t *testing.T) {
 
 		// Run
 		t.Run(strconv.Itoa(n_url), func(t *testing.T) {
-			_, err := cache.CloneOrUpdateMany(urls)
+			_, err := cache.CloneOrUpdateMany(context.Background(), urls)
 			if err != nil {
 				t.Fatal("Error: ", err)
 			}
@@ -225,16 +517,16 @@ func TestPushNotes(t *testing.T) {
 	urls := []string{createLocalGitRepo(t), createLocalGitRepo(t), createLocalGitRepo(t)}
 
 	cache := createLocalGitCache(t)
-	err := cache.AddNotes(urls[0], ref_lock_name, first_note)
+	err := cache.AddNotes(context.Background(), urls[0], ref_lock_name, first_note)
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = cache.PushNotes(urls[0], ref_lock_name)
+	err = cache.PushNotes(context.Background(), urls[0], ref_lock_name)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	out, err := cache.ShowNotes(urls[0], ref_lock_name, "")
+	out, err := cache.ShowNotes(context.Background(), urls[0], ref_lock_name, "")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -253,16 +545,16 @@ func TestAppendNotes(t *testing.T) {
 	urls := []string{createLocalGitRepo(t), createLocalGitRepo(t), createLocalGitRepo(t)}
 
 	cache := createLocalGitCache(t)
-	err := cache.AddNotes(urls[0], ref_lock_name, first_note)
+	err := cache.AddNotes(context.Background(), urls[0], ref_lock_name, first_note)
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = cache.PushNotes(urls[0], ref_lock_name)
+	err = cache.PushNotes(context.Background(), urls[0], ref_lock_name)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	out, err := cache.ShowNotes(urls[0], ref_lock_name, "")
+	out, err := cache.ShowNotes(context.Background(), urls[0], ref_lock_name, "")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -273,12 +565,12 @@ func TestAppendNotes(t *testing.T) {
 		t.Fatal("Could not show first note: " + string(out) + "-" + first_note)
 	}
 
-	err = cache.AppendNotes(urls[0], ref_lock_name, second_note)
+	err = cache.AppendNotes(context.Background(), urls[0], ref_lock_name, second_note)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	out, err = cache.ShowNotes(urls[0], ref_lock_name, "")
+	out, err = cache.ShowNotes(context.Background(), urls[0], ref_lock_name, "")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -303,16 +595,26 @@ func TestPushNotesRejected(t *testing.T) {
 	cache := createLocalGitCache(t)
 
 	cache_other := createLocalGitCache(t)
-	cache_other.AddNotes(urls[0], ref_lock_name, first_note)
-	cache_other.PushNotes(urls[0], ref_lock_name)
+	cache_other.AddNotes(context.Background(), urls[0], ref_lock_name, first_note)
+	cache_other.PushNotes(context.Background(), urls[0], ref_lock_name)
 
-	err := cache.AddNotes(urls[0], ref_lock_name, second_note)
+	err := cache.AddNotes(context.Background(), urls[0], ref_lock_name, second_note)
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = cache.PushNotes(urls[0], ref_lock_name)
-	if err == nil {
-		t.Fatal("Should not be able to push notes.")
+	err = cache.PushNotes(context.Background(), urls[0], ref_lock_name)
+	if !errors.Is(err, ErrNonFastForward) {
+		t.Fatal("Should not be able to push notes: ", err)
+	}
+}
+
+// Runs git in the directory and fails the test on error.
+func runGit(t *testing.T, dir string, args ...string) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Log(string(out))
+		t.Fatal("Failed to run git: " + err.Error())
 	}
 }
 
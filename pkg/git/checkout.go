@@ -1,22 +1,36 @@
 package git
 
+import "context"
+
 // Checkout a branch.
-func Checkout(directory string, branch string) error {
+func Checkout(ctx context.Context, directory string, branch string) error {
 	args := []string{
 		"checkout",
 		branch,
 	}
-	_, err := execute(directory, args)
+	_, err := execute(ctx, directory, args)
 	return err
 }
 
 // Checkout a branch.
-func CheckoutNewBranch(directory string, branch string) error {
+func CheckoutNewBranch(ctx context.Context, directory string, branch string) error {
 	args := []string{
 		"checkout",
 		"-b",
 		branch,
 	}
-	_, err := execute(directory, args)
+	_, err := execute(ctx, directory, args)
+	return err
+}
+
+// CheckoutAt checks out the branch, creating it or resetting it to start.
+func CheckoutAt(ctx context.Context, directory string, branch string, start string) error {
+	args := []string{
+		"checkout",
+		"-B",
+		branch,
+		start,
+	}
+	_, err := execute(ctx, directory, args)
 	return err
 }
@@ -1,14 +1,58 @@
 package git
 
-// Clone also adds a fetch for notes.
-func Clone(url string, directory string) error {
-	args := []string{
-		"clone",
-		"-c",
-		"remote.origin.fetch='refs/notes/*:refs/notes/*'",
-		url,
-		directory,
+import (
+	"context"
+	"strconv"
+)
+
+// CloneMode decides how much of the repository is downloaded.
+type CloneMode string
+
+const (
+	// CloneFull downloads the entire history.
+	CloneFull CloneMode = "full"
+	// CloneBlobless downloads all commits and trees, blobs are fetched when needed.
+	CloneBlobless CloneMode = "blobless"
+	// CloneTreeless downloads all commits, trees and blobs are fetched when needed.
+	CloneTreeless CloneMode = "treeless"
+	// CloneShallow only downloads the last Depth commits of every branch.
+	CloneShallow CloneMode = "shallow"
+)
+
+// CloneStrategy is configured per project for large repositories.
+type CloneStrategy struct {
+	Mode CloneMode
+	// Depth of a shallow clone, defaults to 1.
+	Depth int
+}
+
+// args returns the extra arguments for git clone.
+func (strategy CloneStrategy) args() []string {
+	switch strategy.Mode {
+	case CloneBlobless:
+		return []string{"--filter=blob:none"}
+	case CloneTreeless:
+		return []string{"--filter=tree:0"}
+	case CloneShallow:
+		depth := strategy.Depth
+		if depth <= 0 {
+			depth = 1
+		}
+		return []string{"--depth", strconv.Itoa(depth), "--no-single-branch"}
 	}
-	_, err := execute("", args)
+	return nil
+}
+
+// Clone the entire repository.
+// Notes are not fetched, see FetchNotes.
+func Clone(ctx context.Context, url string, directory string) error {
+	return CloneWithStrategy(ctx, url, directory, CloneStrategy{Mode: CloneFull})
+}
+
+// CloneWithStrategy clones only as much as the strategy requires.
+func CloneWithStrategy(ctx context.Context, url string, directory string, strategy CloneStrategy) error {
+	args := append([]string{"clone"}, strategy.args()...)
+	args = append(args, url, directory)
+	_, err := execute(ctx, "", args)
 	return err
 }
@@ -0,0 +1,168 @@
+package git
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Credentials for the remote of a project.
+// Unset fields fall back to the user's git configuration.
+type Credentials struct {
+	// SSHKey is the path of the private key to use instead of the default ones.
+	SSHKey string
+	// CredentialHelper replaces any credential helpers in the git configuration.
+	CredentialHelper string
+	// HTTPHeaderEnv names an environment variable which holds an HTTP header,
+	// e.g. "Authorization: Bearer <token>", so that the secret stays out of the config.
+	HTTPHeaderEnv string
+}
+
+type credentialsKey struct{}
+
+// WithCredentials returns a context which uses the credentials for every git command.
+func WithCredentials(ctx context.Context, credentials Credentials) context.Context {
+	return context.WithValue(ctx, credentialsKey{}, credentials)
+}
+
+// CredentialsFromContext returns the credentials of the context, if there are any.
+func CredentialsFromContext(ctx context.Context) (Credentials, bool) {
+	credentials, ok := ctx.Value(credentialsKey{}).(Credentials)
+	return credentials, ok
+}
+
+// HTTPHeader reads the header from the environment.
+// Returns an authentication error if the variable is not set.
+func (credentials Credentials) HTTPHeader() (string, error) {
+	if credentials.HTTPHeaderEnv == "" {
+		return "", nil
+	}
+	header := os.Getenv(credentials.HTTPHeaderEnv)
+	if header == "" {
+		return "", Classify(ErrAuthentication, fmt.Errorf("environment variable %s is not set", credentials.HTTPHeaderEnv))
+	}
+	return header, nil
+}
+
+// withCredentials returns a context with the credentials of the URL.
+func (cache *Cache) withCredentials(ctx context.Context, url string) context.Context {
+	key := cache.NormaliseURL(url)
+	for u, credentials := range cache.options.Credentials {
+		if cache.NormaliseURL(u) == key {
+			return WithCredentials(ctx, credentials)
+		}
+	}
+	return ctx
+}
+
+// gitEnvironment never lets git prompt, as there may be nobody to answer.
+// Configuration is passed in the environment rather than with -c, which
+// keeps secrets out of the process list and the trace.
+// git older than 2.31 is refused then, as it would ignore the configuration.
+func gitEnvironment(ctx context.Context) ([]string, error) {
+	env := append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+
+	var config [][2]string
+	if credentials, ok := CredentialsFromContext(ctx); ok {
+		if credentials.SSHKey != "" {
+			env = append(env, "GIT_SSH_COMMAND=ssh -i "+quoteShell(credentials.SSHKey)+" -o IdentitiesOnly=yes -o BatchMode=yes")
+		}
+		if credentials.CredentialHelper != "" {
+			// An empty helper clears the list of helpers.
+			config = append(config, [2]string{"credential.helper", ""}, [2]string{"credential.helper", credentials.CredentialHelper})
+		}
+		header, err := credentials.HTTPHeader()
+		if err != nil {
+			return nil, err
+		}
+		if header != "" {
+			config = append(config, [2]string{"http.extraHeader", header})
+		}
+	}
+	if signing, ok := SigningFromContext(ctx); ok {
+		config = append(config, signing.config()...)
+	}
+	if len(config) == 0 {
+		return env, nil
+	}
+	if err := requireConfigEnvironment(); err != nil {
+		return nil, err
+	}
+
+	// Add to any configuration which is already in the environment.
+	count, _ := strconv.Atoi(os.Getenv("GIT_CONFIG_COUNT"))
+	for i, c := range config {
+		n := strconv.Itoa(count + i)
+		env = append(env, "GIT_CONFIG_KEY_"+n+"="+c[0], "GIT_CONFIG_VALUE_"+n+"="+c[1])
+	}
+	return append(env, "GIT_CONFIG_COUNT="+strconv.Itoa(count+len(config))), nil
+}
+
+// configEnvironmentVersion is the first version of git which reads GIT_CONFIG_COUNT.
+// Older versions ignore it, so the credentials and signing would silently not be used.
+var configEnvironmentVersion = [2]int{2, 31}
+
+var gitVersionOnce sync.Once
+var gitVersionOut string
+var gitVersionErr error
+
+// gitVersion returns the output of git version.
+// git is only asked for its version once.
+func gitVersion() (string, error) {
+	gitVersionOnce.Do(func() {
+		out, err := exec.Command("git", "version").Output()
+		if err != nil {
+			gitVersionErr = fmt.Errorf("could not find the version of git: %w", err)
+			return
+		}
+		gitVersionOut = string(out)
+	})
+	return gitVersionOut, gitVersionErr
+}
+
+// requireConfigEnvironment returns an error if the git binary is too old for GIT_CONFIG_COUNT.
+func requireConfigEnvironment() error {
+	version, err := gitVersion()
+	if err != nil {
+		return err
+	}
+	return checkConfigEnvironment(version)
+}
+
+// checkConfigEnvironment of the output of git version, e.g. "git version 2.39.5".
+func checkConfigEnvironment(version string) error {
+	return checkVersion(version, configEnvironmentVersion, "can't use the configured credentials or signing")
+}
+
+// checkVersion returns an error saying that git can't do something if the output of git version is older than minimum.
+func checkVersion(version string, minimum [2]int, cannot string) error {
+	fields := strings.Fields(version)
+	if len(fields) < 3 {
+		return fmt.Errorf("could not parse the version of git: %q", version)
+	}
+	parts := strings.SplitN(fields[2], ".", 3)
+	if len(parts) < 2 {
+		return fmt.Errorf("could not parse the version of git: %q", version)
+	}
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return fmt.Errorf("could not parse the version of git: %q", version)
+	}
+	minor, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return fmt.Errorf("could not parse the version of git: %q", version)
+	}
+	if major < minimum[0] || major == minimum[0] && minor < minimum[1] {
+		return fmt.Errorf("git %s %s, git %d.%d or later is needed", fields[2], cannot, minimum[0], minimum[1])
+	}
+	return nil
+}
+
+// quoteShell quotes a path for GIT_SSH_COMMAND, which is run by the shell.
+func quoteShell(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,102 @@
+package git
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestGitEnvironmentNoPrompt(t *testing.T) {
+	env, err := gitEnvironment(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env[len(env)-1] != "GIT_TERMINAL_PROMPT=0" {
+		t.Fatal("git must never prompt.")
+	}
+}
+
+func TestGitEnvironmentSSHKey(t *testing.T) {
+	ctx := WithCredentials(context.Background(), Credentials{SSHKey: "/keys/it's"})
+	env, err := gitEnvironment(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `GIT_SSH_COMMAND=ssh -i '/keys/it'\''s' -o IdentitiesOnly=yes -o BatchMode=yes`
+	if env[len(env)-1] != expected {
+		t.Fatalf("Expected %s, got %s", expected, env[len(env)-1])
+	}
+}
+
+func TestCredentialsConfig(t *testing.T) {
+	os.Setenv("GIT_DEPEND_TEST_HEADER", "Authorization: Bearer secret")
+	defer os.Unsetenv("GIT_DEPEND_TEST_HEADER")
+	dir := t.TempDir()
+	if _, err := execute(context.Background(), dir, []string{"init"}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := WithCredentials(context.Background(), Credentials{
+		CredentialHelper: "store",
+		HTTPHeaderEnv:    "GIT_DEPEND_TEST_HEADER",
+	})
+	out, err := execute(ctx, dir, []string{"config", "--get-all", "credential.helper"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The empty helper resets any from the user's configuration.
+	if helpers := strings.Split(strings.TrimSpace(string(out)), "\n"); helpers[len(helpers)-1] != "store" {
+		t.Fatalf("Unexpected helpers: %q", out)
+	}
+	out, err = execute(ctx, dir, []string{"config", "--get", "http.extraHeader"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(string(out)) != "Authorization: Bearer secret" {
+		t.Fatalf("Unexpected header: %q", out)
+	}
+}
+
+func TestCredentialsMissingHeader(t *testing.T) {
+	ctx := WithCredentials(context.Background(), Credentials{HTTPHeaderEnv: "GIT_DEPEND_TEST_MISSING"})
+	if _, err := execute(ctx, t.TempDir(), []string{"init"}); !errors.Is(err, ErrAuthentication) {
+		t.Fatal("Expected an authentication error: ", err)
+	}
+}
+
+func TestCheckConfigEnvironment(t *testing.T) {
+	for _, test := range []struct {
+		version string
+		ok      bool
+	}{
+		{"git version 2.39.5\n", true},
+		{"git version 2.31.0", true},
+		{"git version 2.31.1.windows.1", true},
+		{"git version 3.0.0", true},
+		{"git version 2.30.2", false},
+		{"git version 1.8.3.1", false},
+		{"not git", false},
+	} {
+		if err := checkConfigEnvironment(test.version); (err == nil) != test.ok {
+			t.Errorf("%q: unexpected result %v", test.version, err)
+		}
+	}
+}
+
+func TestCacheCredentials(t *testing.T) {
+	cache, err := NewCacheWithOptions(t.TempDir(), CacheOptions{
+		Credentials: map[string]Credentials{"https://host/org/repo.git": {SSHKey: "key"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Equivalent URLs share credentials.
+	if credentials, ok := CredentialsFromContext(cache.withCredentials(context.Background(), "git@host:org/repo")); !ok || credentials.SSHKey != "key" {
+		t.Fatal("Credentials not found for an equivalent URL.")
+	}
+	if _, ok := CredentialsFromContext(cache.withCredentials(context.Background(), "https://host/org/other")); ok {
+		t.Fatal("Credentials found for another URL.")
+	}
+}
@@ -0,0 +1,65 @@
+package git
+
+import (
+	"context"
+	"fmt"
+)
+
+// Mutation is a change to a ref on a remote.
+// A dry run records them instead of pushing.
+type Mutation struct {
+	URL string
+	Ref string
+	// Old is the SHA which the remote ref is expected to have.
+	// It is empty if the ref is created.
+	Old string
+	New string
+}
+
+// DryRun returns true if the cache records pushes instead of making them.
+func (cache *Cache) DryRun() bool {
+	return cache.options.DryRun
+}
+
+// Mutations returns what a dry run would have pushed, in order.
+func (cache *Cache) Mutations() []Mutation {
+	cache.Lock()
+	defer cache.Unlock()
+	return append([]Mutation(nil), cache.mutations...)
+}
+
+// recordPush records pushing the local ref.
+// The remote is expected to be at the tracking ref, or at whatever was last
+// recorded for the ref, as if that push had succeeded.
+func (cache *Cache) recordPush(ctx context.Context, url string, dir string, ref string, tracking string) error {
+	newSHA, err := cache.backend.ResolveRef(ctx, dir, ref)
+	if err != nil {
+		return err
+	}
+	if newSHA == "" {
+		return fmt.Errorf("src refspec %s does not match any", ref)
+	}
+	oldSHA, err := cache.backend.ResolveRef(ctx, dir, tracking)
+	if err != nil {
+		return err
+	}
+
+	key := cache.NormaliseURL(url) + " " + ref
+	cache.Lock()
+	defer cache.Unlock()
+	if lease, ok := cache.leases[key]; ok {
+		oldSHA = lease
+	}
+	if oldSHA == newSHA {
+		// Everything up-to-date.
+		return nil
+	}
+	cache.leases[key] = newSHA
+	cache.mutations = append(cache.mutations, Mutation{
+		URL: url,
+		Ref: ref,
+		Old: oldSHA,
+		New: newSHA,
+	})
+	return nil
+}
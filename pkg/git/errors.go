@@ -0,0 +1,124 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Kinds of failure which callers may want to handle.
+// Use errors.Is to check for them.
+var (
+	ErrNonFastForward   = errors.New("non-fast-forward")
+	ErrAuthentication   = errors.New("authentication failed")
+	ErrRemoteRefMissing = errors.New("remote ref does not exist")
+	ErrConflict         = errors.New("conflict")
+	ErrLockHeld         = errors.New("lock is held")
+	ErrNetwork          = errors.New("network unreachable")
+	ErrNoteExists       = errors.New("note already exists")
+	ErrNoteMissing      = errors.New("note does not exist")
+)
+
+// classifications are checked in order, as some output matches more than one kind.
+// For example a failed ssh login also says that it could not read from the remote.
+// The patterns are matched against the lower case output.
+var classifications = []struct {
+	kind     error
+	patterns []string
+}{
+	{ErrAuthentication, []string{
+		"authentication failed",
+		// Only ssh's, as local files which can't be written are also "permission denied".
+		"permission denied (publickey",
+		"could not read username",
+		"could not read password",
+		"terminal prompts disabled",
+		"invalid username or password",
+		"host key verification failed",
+		"the requested url returned error: 401",
+		"the requested url returned error: 403",
+	}},
+	{ErrLockHeld, []string{
+		".lock': file exists",
+		"cannot lock ref",
+		"another git process seems to be running",
+	}},
+	{ErrNoteExists, []string{
+		"found existing notes",
+	}},
+	{ErrNoteMissing, []string{
+		"no note found for object",
+		"has no note",
+	}},
+	{ErrConflict, []string{
+		"conflict (",
+		"could not apply",
+		"automatic merge failed",
+		"automatic notes merge failed",
+	}},
+	{ErrRemoteRefMissing, []string{
+		"couldn't find remote ref",
+		"remote ref does not exist",
+	}},
+	{ErrNonFastForward, []string{
+		"[rejected]",
+		"non-fast-forward",
+		"not possible to fast-forward",
+		"updates were rejected",
+		"stale info",
+	}},
+	{ErrNetwork, []string{
+		"could not resolve host",
+		"could not resolve hostname",
+		"unable to access",
+		"failed to connect",
+		"connection refused",
+		"connection timed out",
+		"operation timed out",
+		"network is unreachable",
+		"no route to host",
+		"the remote end hung up unexpectedly",
+		"could not read from remote repository",
+	}},
+}
+
+// classify the output of git.
+// Returns nil if the failure is not one of the known kinds.
+func classify(output []byte) error {
+	lower := strings.ToLower(string(output))
+	for _, c := range classifications {
+		for _, pattern := range c.patterns {
+			if strings.Contains(lower, pattern) {
+				return c.kind
+			}
+		}
+	}
+	return nil
+}
+
+// classifiedError is a failure which didn't come from the git binary.
+type classifiedError struct {
+	kind error
+	err  error
+}
+
+// Classify marks err as a kind of failure, e.g. ErrNetwork.
+// This is for backends which don't run git.
+func Classify(kind error, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &classifiedError{kind, err}
+}
+
+func (e *classifiedError) Error() string {
+	return fmt.Sprintf("%v: %v", e.kind, e.err)
+}
+
+func (e *classifiedError) Unwrap() error {
+	return e.err
+}
+
+func (e *classifiedError) Is(target error) bool {
+	return target == e.kind
+}
@@ -0,0 +1,62 @@
+package git
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		output string
+		kind   error
+	}{
+		{" ! [rejected]        master -> master (fetch first)\nerror: failed to push some refs", ErrNonFastForward},
+		{"fatal: Not possible to fast-forward, aborting.", ErrNonFastForward},
+		{"remote: Invalid username or password.\nfatal: Authentication failed for 'https://host/repo/'", ErrAuthentication},
+		{"git@host: Permission denied (publickey).\nfatal: Could not read from remote repository.", ErrAuthentication},
+		{"fatal: could not read Username for 'https://host': terminal prompts disabled", ErrAuthentication},
+		{"fatal: the requested URL returned error: 403", ErrAuthentication},
+		{"fatal: could not create work tree dir 'repo': Permission denied", nil},
+		{"error: unable to create file a.txt: Permission denied", nil},
+		{"fatal: couldn't find remote ref refs/notes/git-depend-deps", ErrRemoteRefMissing},
+		{"CONFLICT (content): Merge conflict in file.txt", ErrConflict},
+		{"error: could not apply 1234567... Change", ErrConflict},
+		{"fatal: Unable to create '/repo/.git/index.lock': File exists.", ErrLockHeld},
+		{"error: cannot lock ref 'refs/notes/git-depend-lock'", ErrLockHeld},
+		{"fatal: unable to access 'https://host/repo/': Could not resolve host: host", ErrNetwork},
+		{"ssh: connect to host host port 22: Connection refused\nfatal: Could not read from remote repository.", ErrNetwork},
+		{"error: Cannot add notes. Found existing notes for object 1234.", ErrNoteExists},
+		{"fatal: not a git repository", nil},
+	}
+	for _, test := range tests {
+		if kind := classify([]byte(test.output)); kind != test.kind {
+			t.Errorf("%q: expected %v, got %v", test.output, test.kind, kind)
+		}
+	}
+}
+
+func TestExitError(t *testing.T) {
+	_, err := execute(context.Background(), t.TempDir(), []string{"rev-parse", "--verify", "HEAD"})
+	var exitErr *ExitError
+	if !errors.As(err, &exitErr) {
+		t.Fatal("Expected an ExitError: ", err)
+	}
+	if exitErr.ExitCode != 128 {
+		t.Fatal("Unexpected exit code: ", exitErr.ExitCode)
+	}
+	if !strings.HasPrefix(err.Error(), "git rev-parse --verify HEAD: exit status 128: ") {
+		t.Fatal("The command is missing from the error: ", err)
+	}
+}
+
+func TestClassifyConflictOnStdout(t *testing.T) {
+	err := &ExitError{Command: []string{"rebase"}, ExitCode: 1, Stdout: []byte("CONFLICT (content): Merge conflict in a.txt")}
+	if !errors.Is(err, ErrConflict) {
+		t.Fatal("Conflicts are reported on stdout.")
+	}
+	if errors.Is(err, ErrNetwork) {
+		t.Fatal("A conflict is not a network error.")
+	}
+}
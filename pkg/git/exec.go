@@ -2,46 +2,186 @@ package git
 
 import (
 	"bytes"
+	"context"
+	"fmt"
 	"os/exec"
+	"strings"
+	"sync"
+	"time"
 )
 
 // Offers more information that os/exec implementation.
+// Use errors.Is to find the kind of failure, e.g. ErrNonFastForward.
 type ExitError struct {
-	Command []string
-	Stderr  []byte
-	Err     error
+	Command  []string
+	ExitCode int
+	Stdout   []byte
+	Stderr   []byte
+	Err      error
 }
 
-// Return the stderr output.
+// Return the command, exit code and stderr output.
 func (e *ExitError) Error() string {
-	return string(e.Stderr)
+	return fmt.Sprintf("git %s: exit status %d: %s", strings.Join(e.Command, " "), e.ExitCode, bytes.TrimSpace(e.Stderr))
+}
+
+func (e *ExitError) Unwrap() error {
+	return e.Err
+}
+
+// Kind returns the kind of failure, or nil if it is not known.
+// Conflicts are only reported on stdout.
+func (e *ExitError) Kind() error {
+	if kind := classify(e.Stderr); kind != nil {
+		return kind
+	}
+	return classify(e.Stdout)
+}
+
+func (e *ExitError) Is(target error) bool {
+	kind := e.Kind()
+	return kind != nil && kind == target
+}
+
+// DefaultTimeout applies to any git operation without its own timeout.
+const DefaultTimeout = 5 * time.Minute
+
+var timeoutsMutex sync.RWMutex
+
+// timeouts maps the git subcommand to how long it may run for.
+// Operations which use the network get longer.
+var timeouts = map[string]time.Duration{
+	"clone": 60 * time.Minute,
+	"fetch": 30 * time.Minute,
+	"push":  10 * time.Minute,
+	"fsck":  30 * time.Minute,
+}
+
+// SetTimeout for a git subcommand such as clone, fetch or push.
+// Use "default" for all other subcommands.
+// A timeout <= 0 removes the limit.
+func SetTimeout(operation string, timeout time.Duration) {
+	timeoutsMutex.Lock()
+	defer timeoutsMutex.Unlock()
+	timeouts[operation] = timeout
+}
+
+// GetTimeout returns the timeout for a git subcommand.
+func GetTimeout(operation string) time.Duration {
+	timeoutsMutex.RLock()
+	defer timeoutsMutex.RUnlock()
+	if timeout, ok := timeouts[operation]; ok {
+		return timeout
+	}
+	if timeout, ok := timeouts["default"]; ok {
+		return timeout
+	}
+	return DefaultTimeout
+}
+
+// globalOptions of git which take their value as the next argument.
+var globalOptions = map[string]bool{
+	"-c":          true,
+	"-C":          true,
+	"--git-dir":   true,
+	"--work-tree": true,
+	"--namespace": true,
+}
+
+// subcommand of the git command line, after any global options such as -c or -C.
+func subcommand(command []string) string {
+	for i := 0; i < len(command); i++ {
+		if !strings.HasPrefix(command[i], "-") {
+			return command[i]
+		}
+		if globalOptions[command[i]] {
+			i++
+		}
+	}
+	return ""
 }
 
 // Simple git execution.
+// The whole process group is killed if the context is done or the operation times out.
 // Returns the stdout if there is no error.
-// Error can return the stderr if there was an exec.ExitError.
-func execute(directory string, command []string) ([]byte, error) {
+// The error is an *ExitError if git failed.
+func execute(ctx context.Context, directory string, command []string) ([]byte, error) {
+	start := time.Now()
+	stdout, stderr, exitCode, err := run(ctx, directory, command)
+	trace(ctx, directory, command, start, exitCode, stdout, stderr, err)
+	if err != nil {
+		return nil, err
+	}
+	return stdout, nil
+}
+
+// run git and return stdout, stderr and the exit code.
+// The exit code is -1 if git didn't exit by itself.
+func run(ctx context.Context, directory string, command []string) ([]byte, []byte, int, error) {
+	parent := ctx
+	timeout := GetTimeout(subcommand(command))
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	env, err := gitEnvironment(ctx)
+	if err != nil {
+		return nil, nil, -1, err
+	}
 	cmd := exec.Command("git", command...)
+	cmd.Env = env
 
 	var stdout, stderr bytes.Buffer
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
 	cmd.Dir = directory
+	setProcessGroup(cmd)
 
+	if ctx.Err() != nil {
+		return nil, nil, -1, expired(parent, ctx, command, timeout)
+	}
 	if err := cmd.Start(); err != nil {
-		return nil, err
+		return nil, nil, -1, err
 	}
 
-	if err := cmd.Wait(); err != nil {
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			killProcessGroup(cmd)
+		case <-done:
+		}
+	}()
+	err = cmd.Wait()
+	close(done)
+
+	exitCode := cmd.ProcessState.ExitCode()
+	if err != nil {
+		if ctx.Err() != nil {
+			return stdout.Bytes(), stderr.Bytes(), exitCode, expired(parent, ctx, command, timeout)
+		}
 		if _, ok := err.(*exec.ExitError); ok {
-			return nil, &ExitError{
+			return stdout.Bytes(), stderr.Bytes(), exitCode, &ExitError{
 				command,
+				exitCode,
+				stdout.Bytes(),
 				stderr.Bytes(),
 				err,
 			}
 		}
-		return nil, err
+		return stdout.Bytes(), stderr.Bytes(), exitCode, err
 	}
 
-	return stdout.Bytes(), nil
+	return stdout.Bytes(), stderr.Bytes(), exitCode, nil
+}
+
+// expired returns the error of the caller's context if it ended,
+// or else the timeout of the command.
+func expired(parent context.Context, ctx context.Context, command []string, timeout time.Duration) error {
+	if err := parent.Err(); err != nil {
+		return err
+	}
+	return fmt.Errorf("git %s timed out after %s: %w", subcommand(command), timeout, ctx.Err())
 }
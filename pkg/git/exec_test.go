@@ -0,0 +1,73 @@
+package git
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// hang runs a shell alias which holds stdout open in a child process.
+var hang = []string{"-c", "alias.hang=!sleep 30", "hang"}
+
+func TestExecuteTimeout(t *testing.T) {
+	SetTimeout("hang", 100*time.Millisecond)
+	defer func() {
+		timeoutsMutex.Lock()
+		defer timeoutsMutex.Unlock()
+		delete(timeouts, "hang")
+	}()
+
+	start := time.Now()
+	_, err := execute(context.Background(), t.TempDir(), hang)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("Expected a timeout: ", err)
+	}
+	// Wait only returns once the child has closed stdout.
+	if time.Since(start) > 10*time.Second {
+		t.Fatal("Child process was not killed.")
+	}
+}
+
+func TestExecuteTimeoutBeforeStart(t *testing.T) {
+	SetTimeout("hang", time.Nanosecond)
+	defer func() {
+		timeoutsMutex.Lock()
+		defer timeoutsMutex.Unlock()
+		delete(timeouts, "hang")
+	}()
+
+	_, err := execute(context.Background(), t.TempDir(), hang)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("Expected a timeout: ", err)
+	}
+}
+
+func TestSubcommand(t *testing.T) {
+	for _, test := range []struct {
+		command []string
+		want    string
+	}{
+		{[]string{"fetch", "origin"}, "fetch"},
+		{[]string{"-c", "user.name=x", "-C", "dir", "push", "-f"}, "push"},
+		{[]string{"--git-dir", "dir", "--bare", "clone"}, "clone"},
+		{[]string{"-c"}, ""},
+	} {
+		if got := subcommand(test.command); got != test.want {
+			t.Errorf("subcommand(%v) = %q, expected %q", test.command, got, test.want)
+		}
+	}
+}
+
+func TestExecuteCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	start := time.Now()
+	if _, err := execute(ctx, t.TempDir(), hang); err != context.Canceled {
+		t.Fatal("Expected the context to be cancelled: ", err)
+	}
+	if time.Since(start) > 10*time.Second {
+		t.Fatal("Child process was not killed.")
+	}
+}
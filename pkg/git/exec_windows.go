@@ -0,0 +1,16 @@
+package git
+
+import (
+	"os/exec"
+	"strconv"
+)
+
+// setProcessGroup does nothing as taskkill can find the children.
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// killProcessGroup kills git and all of its children.
+func killProcessGroup(cmd *exec.Cmd) {
+	if cmd.Process != nil {
+		exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(cmd.Process.Pid)).Run()
+	}
+}
@@ -0,0 +1,868 @@
+package git
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Errors which look like the output of git, for use with FakeBackend.FailOn.
+var (
+	ErrFakeNetwork  = &ExitError{Command: []string{"fetch"}, ExitCode: 128, Stderr: []byte("fatal: unable to access 'fake': Could not resolve host: fake")}
+	ErrFakeRejected = &ExitError{Command: []string{"push"}, ExitCode: 1, Stderr: []byte("! [rejected] (fetch first)\nerror: failed to push some refs")}
+	ErrFakeConflict = &ExitError{Command: []string{"rebase"}, ExitCode: 1, Stderr: []byte("CONFLICT (content): Merge conflict in fake.txt")}
+)
+
+// fakeMarker is written into the clone directory so that a clone can be found after a rename.
+const fakeMarker = ".fake-git"
+
+// FakeBackend is an in-memory Backend for testing.
+// Remotes are created with AddRemote.
+// Clones only exist in memory, apart from a marker file in the directory.
+type FakeBackend struct {
+	sync.Mutex
+	remotes  map[string]*FakeRemote
+	clones   map[string]*fakeClone
+	failures []*fakeFailure
+	commits  int
+}
+
+// FakeRemote is a repository which the fake clones from and pushes to.
+type FakeRemote struct {
+	backend  *FakeBackend
+	url      string
+	head     string
+	branches map[string][]string
+	notes    map[string]*fakeNotes
+	// conflicts are branches which can't be rebased or merged.
+	conflicts map[string]bool
+}
+
+// fakeNotes is a notes ref.
+// The version is incremented on every push and stands in for the sha of the ref.
+type fakeNotes struct {
+	version int
+	notes   map[string]string
+}
+
+type fakeClone struct {
+	remote   *FakeRemote
+	head     string
+	branches map[string][]string
+	tracking map[string][]string
+	// forks are the branches fetched from other remotes, by {remote}/{branch}.
+	forks map[string][]string
+	notes map[string]*fakeNotes
+	// tracking notes as of the last fetch or push.
+	trackingNotes map[string]*fakeNotes
+}
+
+type fakeFailure struct {
+	op  string
+	url string
+	err error
+}
+
+var _ Backend = &FakeBackend{}
+
+// NewFakeBackend with no remotes.
+func NewFakeBackend() *FakeBackend {
+	return &FakeBackend{
+		remotes: make(map[string]*FakeRemote),
+		clones:  make(map[string]*fakeClone),
+	}
+}
+
+// AddRemote creates a remote with a single commit on master.
+func (fake *FakeBackend) AddRemote(url string) *FakeRemote {
+	fake.Lock()
+	defer fake.Unlock()
+	remote := &FakeRemote{
+		backend:   fake,
+		url:       url,
+		head:      "master",
+		branches:  map[string][]string{"master": {fake.newCommit()}},
+		notes:     make(map[string]*fakeNotes),
+		conflicts: make(map[string]bool),
+	}
+	fake.remotes[NormaliseURL(url)] = remote
+	return remote
+}
+
+// Fork the remote to a new URL with a copy of the branches.
+func (remote *FakeRemote) Fork(url string) *FakeRemote {
+	fake := remote.backend
+	fake.Lock()
+	defer fake.Unlock()
+	fork := &FakeRemote{
+		backend:   fake,
+		url:       url,
+		head:      remote.head,
+		branches:  copyBranches(remote.branches),
+		notes:     make(map[string]*fakeNotes),
+		conflicts: make(map[string]bool),
+	}
+	fake.remotes[NormaliseURL(url)] = fork
+	return fork
+}
+
+// FailOn makes the next call to the Backend method op fail with err.
+// If url is not empty, only calls for that remote fail.
+func (fake *FakeBackend) FailOn(op string, url string, err error) {
+	fake.Lock()
+	defer fake.Unlock()
+	fake.failures = append(fake.failures, &fakeFailure{op, url, err})
+}
+
+// Commit a new commit to the branch, creating it from master if it doesn't exist.
+// Returns the commit.
+func (remote *FakeRemote) Commit(branch string) string {
+	remote.backend.Lock()
+	defer remote.backend.Unlock()
+	commits, ok := remote.branches[branch]
+	if !ok {
+		commits = remote.branches[remote.head]
+	}
+	commit := remote.backend.newCommit()
+	remote.branches[branch] = append(copyCommits(commits), commit)
+	return commit
+}
+
+// Branch returns the commits on the branch, oldest first.
+func (remote *FakeRemote) Branch(branch string) []string {
+	remote.backend.Lock()
+	defer remote.backend.Unlock()
+	return copyCommits(remote.branches[branch])
+}
+
+// Notes returns a copy of the notes ref, mapping the object to the note.
+func (remote *FakeRemote) Notes(ref string) map[string]string {
+	remote.backend.Lock()
+	defer remote.backend.Unlock()
+	return remote.getNotes(ref).copy().notes
+}
+
+// AddNote pushes a note as if it came from somebody else.
+// An empty object means the tip of the default branch.
+func (remote *FakeRemote) AddNote(ref string, object string, note string) {
+	remote.backend.Lock()
+	defer remote.backend.Unlock()
+	if object == "" {
+		object = tip(remote.branches[remote.head])
+	}
+	notes := remote.getNotes(ref)
+	notes.notes[object] = note
+	notes.version++
+}
+
+// Conflict makes any rebase or merge of the branch fail.
+func (remote *FakeRemote) Conflict(branch string) {
+	remote.backend.Lock()
+	defer remote.backend.Unlock()
+	remote.conflicts[branch] = true
+}
+
+func (remote *FakeRemote) getNotes(ref string) *fakeNotes {
+	notes, ok := remote.notes[ref]
+	if !ok {
+		notes = &fakeNotes{notes: make(map[string]string)}
+		remote.notes[ref] = notes
+	}
+	return notes
+}
+
+func (fake *FakeBackend) newCommit() string {
+	fake.commits++
+	h := sha1.Sum([]byte(strconv.Itoa(fake.commits)))
+	return hex.EncodeToString(h[:])
+}
+
+// fail returns any injected failure for the operation.
+// The fake must be locked.
+func (fake *FakeBackend) fail(ctx context.Context, op string, url string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	for i, failure := range fake.failures {
+		if failure.op == op && (failure.url == "" || NormaliseURL(failure.url) == NormaliseURL(url)) {
+			fake.failures = append(fake.failures[:i], fake.failures[i+1:]...)
+			return failure.err
+		}
+	}
+	return nil
+}
+
+// clone finds the clone in the directory and returns any injected failure.
+// The fake must be locked.
+func (fake *FakeBackend) clone(ctx context.Context, op string, directory string) (*fakeClone, error) {
+	clone, err := fake.find(directory)
+	if err != nil {
+		return nil, err
+	}
+	if err := fake.fail(ctx, op, clone.remote.url); err != nil {
+		return nil, err
+	}
+	return clone, nil
+}
+
+// find the clone in the directory by its marker.
+// The fake must be locked.
+func (fake *FakeBackend) find(directory string) (*fakeClone, error) {
+	id, err := ioutil.ReadFile(path.Join(directory, fakeMarker))
+	if err != nil {
+		return nil, fmt.Errorf("not a fake git repository: %s", directory)
+	}
+	clone, ok := fake.clones[string(id)]
+	if !ok {
+		return nil, fmt.Errorf("unknown fake git repository: %s", directory)
+	}
+	return clone, nil
+}
+
+func (fake *FakeBackend) Clone(ctx context.Context, url string, directory string, strategy CloneStrategy) error {
+	fake.Lock()
+	defer fake.Unlock()
+	if err := fake.fail(ctx, "Clone", url); err != nil {
+		return err
+	}
+	remote, ok := fake.remotes[NormaliseURL(url)]
+	if !ok {
+		return &ExitError{Command: []string{"clone", url}, ExitCode: 128, Stderr: []byte("fatal: repository '" + url + "' not found")}
+	}
+	id := strconv.Itoa(len(fake.clones))
+	if err := os.MkdirAll(directory, 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path.Join(directory, fakeMarker), []byte(id), 0644); err != nil {
+		return err
+	}
+	fake.clones[id] = &fakeClone{
+		remote:        remote,
+		head:          remote.head,
+		branches:      map[string][]string{remote.head: copyCommits(remote.branches[remote.head])},
+		tracking:      copyBranches(remote.branches),
+		forks:         make(map[string][]string),
+		notes:         make(map[string]*fakeNotes),
+		trackingNotes: make(map[string]*fakeNotes),
+	}
+	return nil
+}
+
+func (fake *FakeBackend) FetchBranches(ctx context.Context, directory string, remote string) error {
+	fake.Lock()
+	defer fake.Unlock()
+	clone, err := fake.clone(ctx, "FetchBranches", directory)
+	if err != nil {
+		return err
+	}
+	clone.tracking = copyBranches(clone.remote.branches)
+	return nil
+}
+
+func (fake *FakeBackend) FetchBranch(ctx context.Context, directory string, remote string, url string, branch string) error {
+	fake.Lock()
+	defer fake.Unlock()
+	clone, err := fake.clone(ctx, "FetchBranch", directory)
+	if err != nil {
+		return err
+	}
+	fork, ok := fake.remotes[NormaliseURL(url)]
+	if !ok {
+		return &ExitError{Command: []string{"fetch", remote}, ExitCode: 128, Stderr: []byte("fatal: repository '" + url + "' not found")}
+	}
+	commits, ok := fork.branches[branch]
+	if !ok {
+		return &ExitError{Command: []string{"fetch", remote}, ExitCode: 128, Stderr: []byte("fatal: couldn't find remote ref refs/heads/" + branch)}
+	}
+	clone.forks[remote+"/"+branch] = copyCommits(commits)
+	return nil
+}
+
+func (fake *FakeBackend) FetchHistory(ctx context.Context, directory string, remote string, a string, b string) error {
+	fake.Lock()
+	defer fake.Unlock()
+	_, err := fake.clone(ctx, "FetchHistory", directory)
+	return err
+}
+
+func (fake *FakeBackend) FetchNotes(ctx context.Context, directory string, remote string, ref string, strategy NotesMergeStrategy) error {
+	fake.Lock()
+	defer fake.Unlock()
+	clone, err := fake.clone(ctx, "FetchNotes", directory)
+	if err != nil {
+		return err
+	}
+	remoteNotes, ok := clone.remote.notes[ref]
+	if !ok {
+		return nil
+	}
+	tracking := remoteNotes.copy()
+	local, ok := clone.notes[ref]
+	clone.trackingNotes[ref] = tracking
+	// Fast-forward if there are no local changes.
+	if !ok || local.version >= 0 {
+		clone.notes[ref] = tracking.copy()
+		return nil
+	}
+	if local.equal(tracking) {
+		return nil
+	}
+
+	merged := tracking.copy()
+	for object, note := range local.notes {
+		theirs, conflict := merged.notes[object]
+		if !conflict || theirs == note {
+			merged.notes[object] = note
+			continue
+		}
+		switch strategy {
+		case NotesMergeOurs:
+			merged.notes[object] = note
+		case NotesMergeTheirs:
+		case NotesMergeUnion, NotesMergeCatSortUniq:
+			merged.notes[object] = note + "\n\n" + theirs
+		default:
+			return fmt.Errorf("notes %s have diverged from the remote: %w", ref, &ExitError{
+				Command:  []string{"notes", "merge"},
+				ExitCode: 1,
+				Stderr:   []byte("Automatic notes merge failed. Fix conflicts in .git/NOTES_MERGE_WORKTREE"),
+			})
+		}
+	}
+	// The merge is a new local commit on top of the remote.
+	merged.version = -1
+	clone.notes[ref] = merged
+	return nil
+}
+
+func (fake *FakeBackend) ResetNotes(ctx context.Context, directory string, remote string, ref string) error {
+	fake.Lock()
+	defer fake.Unlock()
+	clone, err := fake.clone(ctx, "ResetNotes", directory)
+	if err != nil {
+		return err
+	}
+	if tracking, ok := clone.trackingNotes[ref]; ok {
+		clone.notes[ref] = tracking.copy()
+	} else {
+		delete(clone.notes, ref)
+	}
+	return nil
+}
+
+func (fake *FakeBackend) AddNotes(ctx context.Context, directory string, ref string, note string) error {
+	fake.Lock()
+	defer fake.Unlock()
+	clone, err := fake.clone(ctx, "AddNotes", directory)
+	if err != nil {
+		return err
+	}
+	object := clone.resolve("")
+	notes := clone.localNotes(ref)
+	if _, ok := notes.notes[object]; ok {
+		return &ExitError{
+			Command:  []string{"notes", "--ref", ref, "add"},
+			ExitCode: 128,
+			Stderr:   []byte("error: Cannot add notes. Found existing notes for object " + object + ". Use '-f' to overwrite existing notes"),
+		}
+	}
+	notes.notes[object] = note
+	notes.version = -1
+	return nil
+}
+
+func (fake *FakeBackend) AppendNotes(ctx context.Context, directory string, ref string, note string) error {
+	fake.Lock()
+	defer fake.Unlock()
+	clone, err := fake.clone(ctx, "AppendNotes", directory)
+	if err != nil {
+		return err
+	}
+	object := clone.resolve("")
+	notes := clone.localNotes(ref)
+	if existing, ok := notes.notes[object]; ok {
+		note = existing + "\n\n" + note
+	}
+	notes.notes[object] = note
+	notes.version = -1
+	return nil
+}
+
+func (fake *FakeBackend) SetNotes(ctx context.Context, directory string, ref string, object string, note string) error {
+	fake.Lock()
+	defer fake.Unlock()
+	clone, err := fake.clone(ctx, "SetNotes", directory)
+	if err != nil {
+		return err
+	}
+	notes := clone.localNotes(ref)
+	notes.notes[clone.resolve(object)] = note
+	notes.version = -1
+	return nil
+}
+
+func (fake *FakeBackend) ListNotes(ctx context.Context, directory string, ref string) ([]byte, error) {
+	fake.Lock()
+	defer fake.Unlock()
+	clone, err := fake.clone(ctx, "ListNotes", directory)
+	if err != nil {
+		return nil, err
+	}
+	var lines []string
+	for object, note := range clone.localNotes(ref).notes {
+		h := sha1.Sum([]byte(note))
+		lines = append(lines, hex.EncodeToString(h[:])+" "+object+"\n")
+	}
+	sort.Strings(lines)
+	return []byte(strings.Join(lines, "")), nil
+}
+
+func (fake *FakeBackend) ShowNotes(ctx context.Context, directory string, ref string, object string) ([]byte, error) {
+	fake.Lock()
+	defer fake.Unlock()
+	clone, err := fake.clone(ctx, "ShowNotes", directory)
+	if err != nil {
+		return nil, err
+	}
+	object = clone.resolve(object)
+	note, ok := clone.localNotes(ref).notes[object]
+	if !ok {
+		return nil, &ExitError{
+			Command:  []string{"notes", "--ref", ref, "show"},
+			ExitCode: 128,
+			Stderr:   []byte("error: no note found for object " + object + "."),
+		}
+	}
+	return []byte(note + "\n"), nil
+}
+
+func (fake *FakeBackend) RemoveNotes(ctx context.Context, directory string, ref string, object string) error {
+	fake.Lock()
+	defer fake.Unlock()
+	clone, err := fake.clone(ctx, "RemoveNotes", directory)
+	if err != nil {
+		return err
+	}
+	object = clone.resolve(object)
+	notes := clone.localNotes(ref)
+	if _, ok := notes.notes[object]; !ok {
+		return &ExitError{
+			Command:  []string{"notes", "--ref", ref, "remove"},
+			ExitCode: 128,
+			Stderr:   []byte("error: Object " + object + " has no note"),
+		}
+	}
+	delete(notes.notes, object)
+	notes.version = -1
+	return nil
+}
+
+func (fake *FakeBackend) PushNotes(ctx context.Context, remote string, directory string, ref string) error {
+	fake.Lock()
+	defer fake.Unlock()
+	clone, err := fake.clone(ctx, "PushNotes", directory)
+	if err != nil {
+		return err
+	}
+	local, ok := clone.notes[ref]
+	if !ok {
+		return &ExitError{
+			Command:  []string{"push", remote, "refs/notes/" + ref},
+			ExitCode: 128,
+			Stderr:   []byte("error: src refspec refs/notes/" + ref + " does not match any"),
+		}
+	}
+	if local.version >= 0 {
+		// Everything up-to-date.
+		return nil
+	}
+	remoteNotes := clone.remote.getNotes(ref)
+	base, fetched := clone.trackingNotes[ref]
+	if remoteNotes.version > 0 && (!fetched || base.version != remoteNotes.version) {
+		return ErrFakeRejected
+	}
+	remoteNotes.notes = local.copy().notes
+	remoteNotes.version++
+	local.version = remoteNotes.version
+	clone.trackingNotes[ref] = remoteNotes.copy()
+	return nil
+}
+
+func (fake *FakeBackend) Checkout(ctx context.Context, directory string, branch string) error {
+	fake.Lock()
+	defer fake.Unlock()
+	clone, err := fake.clone(ctx, "Checkout", directory)
+	if err != nil {
+		return err
+	}
+	if _, ok := clone.branches[branch]; !ok {
+		commits, ok := clone.tracking[branch]
+		if !ok {
+			return &ExitError{
+				Command:  []string{"checkout", branch},
+				ExitCode: 128,
+				Stderr:   []byte("error: pathspec '" + branch + "' did not match any file(s) known to git"),
+			}
+		}
+		clone.branches[branch] = copyCommits(commits)
+	}
+	clone.head = branch
+	return nil
+}
+
+func (fake *FakeBackend) CheckoutAt(ctx context.Context, directory string, branch string, start string) error {
+	fake.Lock()
+	defer fake.Unlock()
+	clone, err := fake.clone(ctx, "CheckoutAt", directory)
+	if err != nil {
+		return err
+	}
+	commits := clone.commits(start)
+	if commits == nil {
+		return &ExitError{
+			Command:  []string{"checkout", "-B", branch, start},
+			ExitCode: 128,
+			Stderr:   []byte("fatal: '" + start + "' is not a commit and a branch '" + branch + "' cannot be created from it"),
+		}
+	}
+	clone.branches[branch] = copyCommits(commits)
+	clone.head = branch
+	return nil
+}
+
+func (fake *FakeBackend) Rebase(ctx context.Context, directory string, onto string) error {
+	fake.Lock()
+	defer fake.Unlock()
+	clone, err := fake.clone(ctx, "Rebase", directory)
+	if err != nil {
+		return err
+	}
+	if clone.remote.conflicts[clone.head] {
+		return ErrFakeConflict
+	}
+	base := clone.commits(onto)
+	if base == nil {
+		return fmt.Errorf("fatal: invalid upstream '%s'", onto)
+	}
+	inBase := make(map[string]bool, len(base))
+	for _, commit := range base {
+		inBase[commit] = true
+	}
+	rebased := copyCommits(base)
+	for _, commit := range clone.branches[clone.head] {
+		if !inBase[commit] {
+			rebased = append(rebased, fake.newCommit())
+		}
+	}
+	clone.branches[clone.head] = rebased
+	return nil
+}
+
+func (fake *FakeBackend) Merge(ctx context.Context, directory string, branch string) error {
+	fake.Lock()
+	defer fake.Unlock()
+	clone, err := fake.clone(ctx, "Merge", directory)
+	if err != nil {
+		return err
+	}
+	from := clone.commits(branch)
+	if isPrefix(from, clone.branches[clone.head]) {
+		// Already up to date.
+		return nil
+	}
+	if !isPrefix(clone.branches[clone.head], from) {
+		return &ExitError{Command: []string{"merge", "--ff-only", branch}, ExitCode: 128, Stderr: []byte("fatal: Not possible to fast-forward, aborting.")}
+	}
+	clone.branches[clone.head] = copyCommits(from)
+	return nil
+}
+
+// MergeCommit adds a single commit for the merge to keep the branches linear.
+func (fake *FakeBackend) MergeCommit(ctx context.Context, directory string, branch string, message string) error {
+	return fake.mergeWithCommit(ctx, "MergeCommit", directory, branch)
+}
+
+func (fake *FakeBackend) SquashMerge(ctx context.Context, directory string, branch string, message string) error {
+	return fake.mergeWithCommit(ctx, "SquashMerge", directory, branch)
+}
+
+func (fake *FakeBackend) mergeWithCommit(ctx context.Context, op string, directory string, branch string) error {
+	fake.Lock()
+	defer fake.Unlock()
+	clone, err := fake.clone(ctx, op, directory)
+	if err != nil {
+		return err
+	}
+	if clone.remote.conflicts[branch] {
+		return ErrFakeConflict
+	}
+	if clone.commits(branch) == nil {
+		return &ExitError{Command: []string{"merge", branch}, ExitCode: 1, Stderr: []byte("merge: " + branch + " - not something we can merge")}
+	}
+	clone.branches[clone.head] = append(clone.branches[clone.head], fake.newCommit())
+	return nil
+}
+
+// CanMerge reports a conflict in a file named after the branch if the branch conflicts.
+func (fake *FakeBackend) CanMerge(ctx context.Context, directory string, from string, to string) ([]string, error) {
+	fake.Lock()
+	defer fake.Unlock()
+	clone, err := fake.clone(ctx, "CanMerge", directory)
+	if err != nil {
+		return nil, err
+	}
+	for _, branch := range []string{from, to} {
+		if clone.commits(branch) == nil {
+			return nil, &ExitError{Command: []string{"merge-tree", to, from}, ExitCode: 128, Stderr: []byte("fatal: " + branch + " - not something we can merge")}
+		}
+	}
+	branch := strings.TrimPrefix(from, "origin/")
+	if clone.remote.conflicts[branch] {
+		return []string{branch}, nil
+	}
+	return nil, nil
+}
+
+func (fake *FakeBackend) AheadBehind(ctx context.Context, directory string, from string, to string) (int, int, error) {
+	fake.Lock()
+	defer fake.Unlock()
+	clone, err := fake.clone(ctx, "AheadBehind", directory)
+	if err != nil {
+		return 0, 0, err
+	}
+	commits := [2][]string{clone.commits(from), clone.commits(to)}
+	for i, branch := range []string{from, to} {
+		if commits[i] == nil {
+			return 0, 0, &ExitError{Command: []string{"rev-list", from + "..." + to}, ExitCode: 128, Stderr: []byte("fatal: bad revision '" + branch + "'")}
+		}
+	}
+	return countMissing(commits[0], commits[1]), countMissing(commits[1], commits[0]), nil
+}
+
+// countMissing returns the number of commits which are not in other.
+func countMissing(commits []string, other []string) int {
+	found := make(map[string]bool, len(other))
+	for _, commit := range other {
+		found[commit] = true
+	}
+	n := 0
+	for _, commit := range commits {
+		if !found[commit] {
+			n++
+		}
+	}
+	return n
+}
+
+// SignNotes does nothing as fake commits have no signatures or messages.
+func (fake *FakeBackend) SignNotes(ctx context.Context, directory string, remote string, ref string) error {
+	fake.Lock()
+	defer fake.Unlock()
+	_, err := fake.clone(ctx, "SignNotes", directory)
+	return err
+}
+
+// VerifyCommit accepts any commit which exists.
+func (fake *FakeBackend) VerifyCommit(ctx context.Context, directory string, revision string) error {
+	fake.Lock()
+	defer fake.Unlock()
+	clone, err := fake.clone(ctx, "VerifyCommit", directory)
+	if err != nil {
+		return err
+	}
+	if clone.commits(revision) != nil {
+		return nil
+	}
+	for _, commits := range clone.branches {
+		for _, commit := range commits {
+			if commit == revision {
+				return nil
+			}
+		}
+	}
+	return &ExitError{Command: []string{"verify-commit", revision}, ExitCode: 1, Stderr: []byte("error: " + revision + ": cannot verify a non-commit object")}
+}
+
+// FindCommits finds nothing as fake commits have no messages.
+func (fake *FakeBackend) FindCommits(ctx context.Context, directory string, revision string, text string, since time.Time) ([]string, error) {
+	fake.Lock()
+	defer fake.Unlock()
+	_, err := fake.clone(ctx, "FindCommits", directory)
+	return nil, err
+}
+
+func (fake *FakeBackend) EmptyCommit(ctx context.Context, directory string, message string) error {
+	fake.Lock()
+	defer fake.Unlock()
+	clone, err := fake.clone(ctx, "EmptyCommit", directory)
+	if err != nil {
+		return err
+	}
+	clone.branches[clone.head] = append(clone.branches[clone.head], fake.newCommit())
+	return nil
+}
+
+func (fake *FakeBackend) Push(ctx context.Context, remote string, directory string, branch string) error {
+	fake.Lock()
+	defer fake.Unlock()
+	clone, err := fake.clone(ctx, "Push", directory)
+	if err != nil {
+		return err
+	}
+	local := clone.branches[branch]
+	if !isPrefix(clone.remote.branches[branch], local) {
+		return ErrFakeRejected
+	}
+	clone.remote.branches[branch] = copyCommits(local)
+	clone.tracking[branch] = copyCommits(local)
+	return nil
+}
+
+// ResolveRef understands branches, remote tracking branches and notes.
+// The SHA of notes is a hash of their contents.
+func (fake *FakeBackend) ResolveRef(ctx context.Context, directory string, ref string) (string, error) {
+	fake.Lock()
+	defer fake.Unlock()
+	clone, err := fake.clone(ctx, "ResolveRef", directory)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case strings.HasPrefix(ref, "refs/heads/"):
+		return tip(clone.branches[strings.TrimPrefix(ref, "refs/heads/")]), nil
+	case strings.HasPrefix(ref, "refs/remotes/origin/"):
+		return tip(clone.tracking[strings.TrimPrefix(ref, "refs/remotes/origin/")]), nil
+	case strings.HasPrefix(ref, "refs/notes/remotes/origin/"):
+		return clone.trackingNotes[strings.TrimPrefix(ref, "refs/notes/remotes/origin/")].sha(), nil
+	case strings.HasPrefix(ref, "refs/notes/"):
+		return clone.notes[strings.TrimPrefix(ref, "refs/notes/")].sha(), nil
+	case strings.HasPrefix(ref, "refs/remotes/"):
+		return tip(clone.forks[strings.TrimPrefix(ref, "refs/remotes/")]), nil
+	}
+	return "", nil
+}
+
+// Inspect reports an injected failure as a corrupt clone, so that it can be repaired.
+// Fake clones are never detached or dirty.
+func (fake *FakeBackend) Inspect(ctx context.Context, directory string) (RepositoryState, error) {
+	fake.Lock()
+	defer fake.Unlock()
+	clone, err := fake.find(directory)
+	if err != nil {
+		return RepositoryState{}, err
+	}
+	state := RepositoryState{Origin: clone.remote.url}
+	if err := fake.fail(ctx, "Inspect", clone.remote.url); err != nil {
+		state.Corrupt = true
+	}
+	return state, nil
+}
+
+// resolve an object to a commit, defaulting to HEAD.
+func (clone *fakeClone) resolve(object string) string {
+	if object == "" || object == "HEAD" {
+		return tip(clone.branches[clone.head])
+	}
+	if commits := clone.commits(object); commits != nil {
+		return tip(commits)
+	}
+	return object
+}
+
+// commits of a local branch or origin/ branch.
+func (clone *fakeClone) commits(name string) []string {
+	if commits, ok := clone.branches[name]; ok {
+		return commits
+	}
+	if commits, ok := clone.forks[name]; ok {
+		return commits
+	}
+	return clone.tracking[strings.TrimPrefix(name, "origin/")]
+}
+
+func (clone *fakeClone) localNotes(ref string) *fakeNotes {
+	notes, ok := clone.notes[ref]
+	if !ok {
+		notes = &fakeNotes{notes: make(map[string]string)}
+		clone.notes[ref] = notes
+	}
+	return notes
+}
+
+func (notes *fakeNotes) copy() *fakeNotes {
+	c := &fakeNotes{version: notes.version, notes: make(map[string]string, len(notes.notes))}
+	for k, v := range notes.notes {
+		c.notes[k] = v
+	}
+	return c
+}
+
+// sha is empty if the notes ref doesn't exist.
+func (notes *fakeNotes) sha() string {
+	if notes == nil {
+		return ""
+	}
+	objects := make([]string, 0, len(notes.notes))
+	for object := range notes.notes {
+		objects = append(objects, object)
+	}
+	sort.Strings(objects)
+	h := sha1.New()
+	for _, object := range objects {
+		fmt.Fprintf(h, "%s %s\n", object, notes.notes[object])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (notes *fakeNotes) equal(other *fakeNotes) bool {
+	if len(notes.notes) != len(other.notes) {
+		return false
+	}
+	for k, v := range notes.notes {
+		if o, ok := other.notes[k]; !ok || o != v {
+			return false
+		}
+	}
+	return true
+}
+
+func tip(commits []string) string {
+	if len(commits) == 0 {
+		return ""
+	}
+	return commits[len(commits)-1]
+}
+
+// isPrefix returns true if a is an ancestor of b.
+func isPrefix(a []string, b []string) bool {
+	if len(a) > len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func copyCommits(commits []string) []string {
+	return append([]string(nil), commits...)
+}
+
+func copyBranches(branches map[string][]string) map[string][]string {
+	c := make(map[string][]string, len(branches))
+	for k, v := range branches {
+		c[k] = copyCommits(v)
+	}
+	return c
+}
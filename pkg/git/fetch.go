@@ -1,7 +1,144 @@
 package git
 
-func Fetch(directory string) error {
-	args := []string{"fetch"}
-	_, err := execute(directory, args)
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+)
+
+// NotesMergeStrategy resolves a notes ref which has diverged from the remote.
+// These are the strategies of git notes merge.
+type NotesMergeStrategy string
+
+const (
+	NotesMergeManual      NotesMergeStrategy = "manual"
+	NotesMergeOurs        NotesMergeStrategy = "ours"
+	NotesMergeTheirs      NotesMergeStrategy = "theirs"
+	NotesMergeUnion       NotesMergeStrategy = "union"
+	NotesMergeCatSortUniq NotesMergeStrategy = "cat_sort_uniq"
+)
+
+// FetchBranches updates the remote tracking branches.
+func FetchBranches(ctx context.Context, directory string, remote string) error {
+	args := []string{
+		"fetch",
+		"--prune",
+		remote,
+		"+refs/heads/*:refs/remotes/" + remote + "/*",
+	}
+	_, err := execute(ctx, directory, args)
+	return err
+}
+
+// FetchBranch fetches a single branch from another repository, e.g. a fork,
+// into refs/remotes/{remote}/{branch}.
+// The remote is added, or its URL updated, first.
+func FetchBranch(ctx context.Context, directory string, remote string, url string, branch string) error {
+	if _, err := execute(ctx, directory, []string{"config", "remote." + remote + ".url", url}); err != nil {
+		return err
+	}
+	args := []string{
+		"fetch",
+		remote,
+		"+refs/heads/" + branch + ":refs/remotes/" + remote + "/" + branch,
+	}
+	_, err := execute(ctx, directory, args)
 	return err
 }
+
+// FetchNotes fetches refs/notes/{ref} into refs/notes/remotes/{remote}/{ref}
+// and then updates the local notes ref.
+// If the local notes have diverged, they are merged using the strategy.
+// It is not an error for the remote to have no notes.
+func FetchNotes(ctx context.Context, directory string, remote string, ref string, strategy NotesMergeStrategy) error {
+	tracking := remoteNotesRef(remote, ref)
+	args := []string{
+		"fetch",
+		remote,
+		"+refs/notes/" + ref + ":" + tracking,
+	}
+	if _, err := execute(ctx, directory, args); err != nil {
+		if errors.Is(err, ErrRemoteRefMissing) {
+			return nil
+		}
+		return err
+	}
+
+	local := "refs/notes/" + ref
+	localSHA, err := resolveRef(ctx, directory, local)
+	if err != nil {
+		return err
+	}
+	remoteSHA, err := resolveRef(ctx, directory, tracking)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case localSHA == remoteSHA:
+		return nil
+	case localSHA == "" || isAncestor(ctx, directory, localSHA, remoteSHA):
+		// Fast-forward.
+		_, err := execute(ctx, directory, []string{"update-ref", local, remoteSHA})
+		return err
+	case isAncestor(ctx, directory, remoteSHA, localSHA):
+		// Local notes have not been pushed yet.
+		return nil
+	}
+	return mergeNotes(ctx, directory, ref, tracking, strategy)
+}
+
+// ResetNotes discards any local notes which have not been pushed to the remote.
+func ResetNotes(ctx context.Context, directory string, remote string, ref string) error {
+	remoteSHA, err := resolveRef(ctx, directory, remoteNotesRef(remote, ref))
+	if err != nil {
+		return err
+	}
+	args := []string{"update-ref", "refs/notes/" + ref, remoteSHA}
+	if remoteSHA == "" {
+		args = []string{"update-ref", "-d", "refs/notes/" + ref}
+	}
+	_, err = execute(ctx, directory, args)
+	return err
+}
+
+// mergeNotes merges the remote tracking notes into the local notes.
+// A manual merge which conflicts is aborted, as there is nobody to resolve it.
+func mergeNotes(ctx context.Context, directory string, ref string, tracking string, strategy NotesMergeStrategy) error {
+	if strategy == "" {
+		strategy = NotesMergeManual
+	}
+	args := []string{"merge", "-q", "-s", string(strategy), tracking}
+	if _, err := Notes(ctx, directory, ref, args); err != nil {
+		if strategy == NotesMergeManual {
+			Notes(ctx, directory, ref, []string{"merge", "--abort"})
+		}
+		return fmt.Errorf("notes %s have diverged from the remote: %w", ref, err)
+	}
+	return nil
+}
+
+func remoteNotesRef(remote string, ref string) string {
+	return "refs/notes/remotes/" + remote + "/" + ref
+}
+
+// resolveRef returns an empty string if the ref doesn't exist.
+func resolveRef(ctx context.Context, directory string, ref string) (string, error) {
+	args := []string{"rev-parse", "--verify", "--quiet", ref}
+	out, err := execute(ctx, directory, args)
+	var exitErr *ExitError
+	if errors.As(err, &exitErr) && len(bytes.TrimSpace(exitErr.Stderr)) == 0 {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	return string(bytes.TrimSpace(out)), nil
+}
+
+// isAncestor returns true if a is an ancestor of b.
+func isAncestor(ctx context.Context, directory string, a string, b string) bool {
+	args := []string{"merge-base", "--is-ancestor", a, b}
+	_, err := execute(ctx, directory, args)
+	return err == nil
+}
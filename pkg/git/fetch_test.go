@@ -0,0 +1,97 @@
+package git
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestCloneKeepsBranchRefspec(t *testing.T) {
+	url := createLocalGitRepo(t)
+	cache := createLocalGitCache(t)
+
+	dir, err := cache.GetRepositoryDirectory(context.Background(), url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := execute(context.Background(), dir, []string{"config", "--get-all", "remote.origin.fetch"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(string(out)) != "+refs/heads/*:refs/remotes/origin/*" {
+		t.Fatal("Unexpected refspec: " + string(out))
+	}
+}
+
+func TestFetchNotes(t *testing.T) {
+	ref := "test-deps"
+	url := createLocalGitRepo(t)
+	cache := createLocalGitCache(t)
+	cache_other := createLocalGitCache(t)
+
+	// No notes on the remote yet.
+	if err := cache.FetchNotes(context.Background(), url, ref); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cache_other.AddNotes(context.Background(), url, ref, "first note"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache_other.PushNotes(context.Background(), url, ref); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cache.FetchNotes(context.Background(), url, ref); err != nil {
+		t.Fatal(err)
+	}
+	out, err := cache.ShowNotes(context.Background(), url, ref, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(string(out)) != "first note" {
+		t.Fatal("Notes not fetched: " + string(out))
+	}
+}
+
+func TestFetchNotesDiverged(t *testing.T) {
+	strategies := map[NotesMergeStrategy]string{
+		NotesMergeTheirs: "remote note",
+		NotesMergeOurs:   "local note",
+	}
+	for strategy, expected := range strategies {
+		t.Run(string(strategy), func(t *testing.T) {
+			ref := "test-deps"
+			url := createLocalGitRepo(t)
+			cache, err := NewCacheWithOptions(t.TempDir(), CacheOptions{NotesMergeStrategy: strategy})
+			if err != nil {
+				t.Fatal(err)
+			}
+			cache_other := createLocalGitCache(t)
+
+			if err := cache.AddNotes(context.Background(), url, ref, "local note"); err != nil {
+				t.Fatal(err)
+			}
+			if err := cache_other.AddNotes(context.Background(), url, ref, "remote note"); err != nil {
+				t.Fatal(err)
+			}
+			if err := cache_other.PushNotes(context.Background(), url, ref); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := cache.FetchNotes(context.Background(), url, ref); err != nil {
+				t.Fatal(err)
+			}
+			out, err := cache.ShowNotes(context.Background(), url, ref, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.TrimSpace(string(out)) != expected {
+				t.Fatal("Notes not merged: " + string(out))
+			}
+			// The merged notes can now be pushed.
+			if err := cache.PushNotes(context.Background(), url, ref); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
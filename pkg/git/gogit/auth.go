@@ -0,0 +1,83 @@
+package gogit
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/git-depend/git-depend/pkg/git"
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
+)
+
+// authMethod returns the go-git equivalent of the credentials in the context.
+// Credential helpers can't be run by go-git.
+func authMethod(ctx context.Context, url string) (transport.AuthMethod, error) {
+	credentials, ok := git.CredentialsFromContext(ctx)
+	if !ok {
+		return nil, nil
+	}
+	if credentials.CredentialHelper != "" {
+		return nil, fmt.Errorf("credential helper: %w", ErrUnsupported)
+	}
+	endpoint, err := transport.NewEndpoint(url)
+	if err != nil {
+		return nil, err
+	}
+
+	switch endpoint.Protocol {
+	case "ssh":
+		if credentials.SSHKey == "" {
+			return nil, nil
+		}
+		keys, err := ssh.NewPublicKeysFromFile(endpoint.User, credentials.SSHKey, "")
+		if err != nil {
+			return nil, git.Classify(git.ErrAuthentication, err)
+		}
+		return keys, nil
+	case "http", "https":
+		header, err := credentials.HTTPHeader()
+		if err != nil || header == "" {
+			return nil, err
+		}
+		return httpAuth(header)
+	}
+	return nil, nil
+}
+
+// httpAuth supports Authorization headers with a bearer token or basic credentials.
+func httpAuth(header string) (transport.AuthMethod, error) {
+	parts := strings.SplitN(header, ":", 2)
+	if len(parts) != 2 || !strings.EqualFold(strings.TrimSpace(parts[0]), "Authorization") {
+		return nil, fmt.Errorf("http header %s: %w", strings.TrimSpace(parts[0]), ErrUnsupported)
+	}
+	value := strings.TrimSpace(parts[1])
+	fields := strings.SplitN(value, " ", 2)
+	if len(fields) == 2 && strings.EqualFold(fields[0], "Bearer") {
+		return &http.TokenAuth{Token: strings.TrimSpace(fields[1])}, nil
+	}
+	if len(fields) == 2 && strings.EqualFold(fields[0], "Basic") {
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(fields[1]))
+		if err != nil {
+			return nil, git.Classify(git.ErrAuthentication, err)
+		}
+		userPass := strings.SplitN(string(decoded), ":", 2)
+		if len(userPass) != 2 {
+			return nil, git.Classify(git.ErrAuthentication, fmt.Errorf("basic credentials must be user:password"))
+		}
+		return &http.BasicAuth{Username: userPass[0], Password: userPass[1]}, nil
+	}
+	return nil, fmt.Errorf("authorization scheme: %w", ErrUnsupported)
+}
+
+// remoteAuth returns the auth for the URL of the remote.
+func remoteAuth(ctx context.Context, repo *gogit.Repository, remote string) (transport.AuthMethod, error) {
+	r, err := repo.Remote(remote)
+	if err != nil {
+		return nil, err
+	}
+	return authMethod(ctx, r.Config().URLs[0])
+}
@@ -0,0 +1,502 @@
+// Package gogit is a git.Backend which doesn't need the git binary.
+// It uses go-git, so it can run in containers which only have git-dep.
+// Local remotes are still served by git-upload-pack and git-receive-pack.
+package gogit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/git-depend/git-depend/pkg/git"
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// ErrUnsupported is returned for operations which go-git can't do.
+var ErrUnsupported = errors.New("not supported by the go backend")
+
+// Backend implements git.Backend with go-git.
+// Every clone is a full clone, as go-git can't filter or deepen.
+type Backend struct{}
+
+var _ git.Backend = Backend{}
+
+func (Backend) Clone(ctx context.Context, url string, directory string, strategy git.CloneStrategy) error {
+	auth, err := authMethod(ctx, url)
+	if err != nil {
+		return err
+	}
+	_, err = gogit.PlainCloneContext(ctx, directory, false, &gogit.CloneOptions{
+		URL:        url,
+		Auth:       auth,
+		RemoteName: "origin",
+		Tags:       gogit.NoTags,
+	})
+	return classify(err)
+}
+
+func (Backend) FetchBranches(ctx context.Context, directory string, remote string) error {
+	repo, err := gogit.PlainOpen(directory)
+	if err != nil {
+		return err
+	}
+	spec := config.RefSpec("+refs/heads/*:refs/remotes/" + remote + "/*")
+	if err := fetch(ctx, repo, remote, spec); err != nil {
+		return err
+	}
+	return prune(ctx, repo, remote, spec)
+}
+
+// FetchBranch fetches one branch from the URL, e.g. a fork, into refs/remotes/{remote}/{branch}.
+func (Backend) FetchBranch(ctx context.Context, directory string, remote string, url string, branch string) error {
+	repo, err := gogit.PlainOpen(directory)
+	if err != nil {
+		return err
+	}
+	// Add the remote, or update its URL.
+	cfg, err := repo.Config()
+	if err != nil {
+		return err
+	}
+	cfg.Remotes[remote] = &config.RemoteConfig{Name: remote, URLs: []string{url}}
+	if err := repo.SetConfig(cfg); err != nil {
+		return err
+	}
+	spec := config.RefSpec("+" + plumbing.NewBranchReferenceName(branch) + ":" + plumbing.NewRemoteReferenceName(remote, branch))
+	return fetch(ctx, repo, remote, spec)
+}
+
+// FetchHistory has nothing to do, as the clone is never shallow.
+func (Backend) FetchHistory(ctx context.Context, directory string, remote string, a string, b string) error {
+	return ctx.Err()
+}
+
+func (Backend) PushNotes(ctx context.Context, remote string, directory string, ref string) error {
+	return push(ctx, directory, remote, notesRef(ref))
+}
+
+func (Backend) Checkout(ctx context.Context, directory string, branch string) error {
+	repo, worktree, err := open(directory)
+	if err != nil {
+		return err
+	}
+	name := plumbing.NewBranchReferenceName(branch)
+	options := &gogit.CheckoutOptions{Branch: name}
+	if _, err := repo.Reference(name, false); err == plumbing.ErrReferenceNotFound {
+		tracking, err := repo.Reference(plumbing.NewRemoteReferenceName("origin", branch), true)
+		if err != nil {
+			return fmt.Errorf("pathspec '%s' did not match any branch: %w", branch, err)
+		}
+		options.Create = true
+		options.Hash = tracking.Hash()
+	} else if err != nil {
+		return err
+	}
+	return worktree.Checkout(options)
+}
+
+func (Backend) CheckoutAt(ctx context.Context, directory string, branch string, start string) error {
+	repo, worktree, err := open(directory)
+	if err != nil {
+		return err
+	}
+	hash, err := repo.ResolveRevision(plumbing.Revision(start))
+	if err != nil {
+		return fmt.Errorf("%s: %w", start, err)
+	}
+	name := plumbing.NewBranchReferenceName(branch)
+	if err := repo.Storer.SetReference(plumbing.NewHashReference(name, *hash)); err != nil {
+		return err
+	}
+	return worktree.Checkout(&gogit.CheckoutOptions{Branch: name, Force: true})
+}
+
+// Rebase only succeeds if no commits need to be rewritten,
+// i.e. HEAD already contains onto, or HEAD can be fast-forwarded to it.
+func (Backend) Rebase(ctx context.Context, directory string, onto string) error {
+	repo, worktree, err := open(directory)
+	if err != nil {
+		return err
+	}
+	head, target, err := headAndRevision(repo, onto)
+	if err != nil {
+		return err
+	}
+	if ok, err := target.IsAncestor(head); ok || err != nil {
+		return err
+	}
+	if ok, err := head.IsAncestor(target); err != nil {
+		return err
+	} else if !ok {
+		return fmt.Errorf("rebase onto %s: %w", onto, ErrUnsupported)
+	}
+	return worktree.Reset(&gogit.ResetOptions{Commit: target.Hash, Mode: gogit.HardReset})
+}
+
+// Merge fast-forwards HEAD to the branch.
+func (Backend) Merge(ctx context.Context, directory string, branch string) error {
+	repo, worktree, err := open(directory)
+	if err != nil {
+		return err
+	}
+	head, target, err := headAndRevision(repo, branch)
+	if err != nil {
+		return err
+	}
+	if ok, err := head.IsAncestor(target); err != nil {
+		return err
+	} else if !ok {
+		if ok, err := target.IsAncestor(head); ok || err != nil {
+			// Already up to date.
+			return err
+		}
+		return git.Classify(git.ErrNonFastForward, fmt.Errorf("merge %s: not possible to fast-forward", branch))
+	}
+	return worktree.Reset(&gogit.ResetOptions{Commit: target.Hash, Mode: gogit.HardReset})
+}
+
+// MergeCommit is only supported if the branch has all of HEAD,
+// as there is no content merge.
+func (Backend) MergeCommit(ctx context.Context, directory string, branch string, message string) error {
+	return commitBranch(ctx, directory, branch, message, true)
+}
+
+// SquashMerge is only supported if the branch has all of HEAD.
+func (Backend) SquashMerge(ctx context.Context, directory string, branch string, message string) error {
+	return commitBranch(ctx, directory, branch, message, false)
+}
+
+// commitBranch commits the tree of the branch on top of HEAD.
+// The branch is also a parent of a merge commit.
+func commitBranch(ctx context.Context, directory string, branch string, message string, merge bool) error {
+	if err := unsigned(ctx); err != nil {
+		return err
+	}
+	repo, worktree, err := open(directory)
+	if err != nil {
+		return err
+	}
+	author, err := signature(repo)
+	if err != nil {
+		return err
+	}
+	head, target, err := headAndRevision(repo, branch)
+	if err != nil {
+		return err
+	}
+	if ok, err := head.IsAncestor(target); err != nil {
+		return err
+	} else if !ok {
+		return fmt.Errorf("merge %s: %w", branch, ErrUnsupported)
+	}
+	parents := []plumbing.Hash{head.Hash}
+	if merge {
+		parents = append(parents, target.Hash)
+	}
+	// Take the tree of the branch, then commit it with the right parents.
+	if err := worktree.Reset(&gogit.ResetOptions{Commit: target.Hash, Mode: gogit.HardReset}); err != nil {
+		return err
+	}
+	_, err = worktree.Commit(message, &gogit.CommitOptions{Author: author, Parents: parents})
+	return err
+}
+
+// CanMerge is only supported if one of the branches has all of the other,
+// as there is no content merge.
+func (Backend) CanMerge(ctx context.Context, directory string, from string, to string) ([]string, error) {
+	repo, err := gogit.PlainOpen(directory)
+	if err != nil {
+		return nil, err
+	}
+	var commits [2]*object.Commit
+	for i, revision := range []string{from, to} {
+		hash, err := repo.ResolveRevision(plumbing.Revision(revision))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", revision, err)
+		}
+		if commits[i], err = repo.CommitObject(*hash); err != nil {
+			return nil, err
+		}
+	}
+	for _, pair := range [][2]*object.Commit{{commits[0], commits[1]}, {commits[1], commits[0]}} {
+		if ok, err := pair[0].IsAncestor(pair[1]); ok || err != nil {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("merge-tree %s %s: %w", to, from, ErrUnsupported)
+}
+
+func (Backend) AheadBehind(ctx context.Context, directory string, from string, to string) (int, int, error) {
+	repo, err := gogit.PlainOpen(directory)
+	if err != nil {
+		return 0, 0, err
+	}
+	var ancestors [2]map[plumbing.Hash]bool
+	for i, revision := range []string{from, to} {
+		hash, err := repo.ResolveRevision(plumbing.Revision(revision))
+		if err != nil {
+			return 0, 0, fmt.Errorf("%s: %w", revision, err)
+		}
+		commit, err := repo.CommitObject(*hash)
+		if err != nil {
+			return 0, 0, err
+		}
+		ancestors[i] = make(map[plumbing.Hash]bool)
+		err = object.NewCommitPreorderIter(commit, nil, nil).ForEach(func(c *object.Commit) error {
+			ancestors[i][c.Hash] = true
+			return ctx.Err()
+		})
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	counts := [2]int{}
+	for i := range ancestors {
+		for hash := range ancestors[i] {
+			if !ancestors[1-i][hash] {
+				counts[i]++
+			}
+		}
+	}
+	return counts[0], counts[1], nil
+}
+
+func (Backend) EmptyCommit(ctx context.Context, directory string, message string) error {
+	if err := unsigned(ctx); err != nil {
+		return err
+	}
+	repo, worktree, err := open(directory)
+	if err != nil {
+		return err
+	}
+	author, err := signature(repo)
+	if err != nil {
+		return err
+	}
+	_, err = worktree.Commit(message, &gogit.CommitOptions{Author: author})
+	return err
+}
+
+// SignNotes is not supported, so signing always fails.
+// Without signing there is nothing to do, as FindCommits can't find the transaction anyway.
+func (Backend) SignNotes(ctx context.Context, directory string, remote string, ref string) error {
+	if _, ok := git.SigningFromContext(ctx); !ok {
+		return nil
+	}
+	return fmt.Errorf("sign notes: %w", ErrUnsupported)
+}
+
+func (Backend) VerifyCommit(ctx context.Context, directory string, revision string) error {
+	return fmt.Errorf("verify commit: %w", ErrUnsupported)
+}
+
+func (Backend) FindCommits(ctx context.Context, directory string, revision string, text string, since time.Time) ([]string, error) {
+	return nil, fmt.Errorf("find commits: %w", ErrUnsupported)
+}
+
+func (Backend) Push(ctx context.Context, remote string, directory string, branch string) error {
+	return push(ctx, directory, remote, plumbing.NewBranchReferenceName(branch))
+}
+
+func (Backend) ResolveRef(ctx context.Context, directory string, ref string) (string, error) {
+	repo, err := gogit.PlainOpen(directory)
+	if err != nil {
+		return "", err
+	}
+	reference, err := repo.Reference(plumbing.ReferenceName(ref), true)
+	if err == plumbing.ErrReferenceNotFound {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	return reference.Hash().String(), nil
+}
+
+func (Backend) Inspect(ctx context.Context, directory string) (git.RepositoryState, error) {
+	var state git.RepositoryState
+	repo, err := gogit.PlainOpen(directory)
+	if err != nil {
+		return state, err
+	}
+	remote, err := repo.Remote("origin")
+	if err != nil {
+		return state, err
+	}
+	if urls := remote.Config().URLs; len(urls) > 0 {
+		state.Origin = urls[0]
+	}
+
+	// Reading every commit stands in for git fsck.
+	commits, err := repo.CommitObjects()
+	if err != nil {
+		state.Corrupt = true
+		return state, nil
+	}
+	if err := commits.ForEach(func(*object.Commit) error { return nil }); err != nil {
+		state.Corrupt = true
+		return state, nil
+	}
+
+	head, err := repo.Reference(plumbing.HEAD, false)
+	if err != nil || head.Type() != plumbing.SymbolicReference {
+		state.Detached = true
+	}
+
+	worktree, err := repo.Worktree()
+	if err != nil {
+		state.Corrupt = true
+		return state, nil
+	}
+	status, err := worktree.Status()
+	if err != nil {
+		state.Corrupt = true
+	} else if !status.IsClean() {
+		state.Dirty = true
+	}
+	return state, nil
+}
+
+func open(directory string) (*gogit.Repository, *gogit.Worktree, error) {
+	repo, err := gogit.PlainOpen(directory)
+	if err != nil {
+		return nil, nil, err
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return nil, nil, err
+	}
+	return repo, worktree, nil
+}
+
+func fetch(ctx context.Context, repo *gogit.Repository, remote string, specs ...config.RefSpec) error {
+	auth, err := remoteAuth(ctx, repo, remote)
+	if err != nil {
+		return err
+	}
+	err = repo.FetchContext(ctx, &gogit.FetchOptions{
+		RemoteName: remote,
+		Auth:       auth,
+		RefSpecs:   specs,
+		Tags:       gogit.NoTags,
+	})
+	if err == gogit.NoErrAlreadyUpToDate {
+		return nil
+	}
+	return classify(err)
+}
+
+// prune removes the tracking refs of the spec which no longer exist on the remote.
+func prune(ctx context.Context, repo *gogit.Repository, remote string, spec config.RefSpec) error {
+	r, err := repo.Remote(remote)
+	if err != nil {
+		return err
+	}
+	auth, err := remoteAuth(ctx, repo, remote)
+	if err != nil {
+		return err
+	}
+	advertised, err := r.List(&gogit.ListOptions{Auth: auth})
+	if err != nil {
+		return classify(err)
+	}
+	exists := make(map[plumbing.ReferenceName]bool, len(advertised))
+	for _, ref := range advertised {
+		if spec.Match(ref.Name()) {
+			exists[spec.Dst(ref.Name())] = true
+		}
+	}
+	refs, err := repo.References()
+	if err != nil {
+		return err
+	}
+	prefix := "refs/remotes/" + remote + "/"
+	return refs.ForEach(func(ref *plumbing.Reference) error {
+		name := ref.Name()
+		if strings.HasPrefix(name.String(), prefix) && ref.Type() == plumbing.HashReference && !exists[name] {
+			return repo.Storer.RemoveReference(name)
+		}
+		return nil
+	})
+}
+
+// push the ref to the same name on the remote.
+// The remote must be fast-forwarded.
+func push(ctx context.Context, directory string, remote string, name plumbing.ReferenceName) error {
+	repo, err := gogit.PlainOpen(directory)
+	if err != nil {
+		return err
+	}
+	if _, err := repo.Reference(name, false); err != nil {
+		return fmt.Errorf("src refspec %s does not match any: %w", name, err)
+	}
+	auth, err := remoteAuth(ctx, repo, remote)
+	if err != nil {
+		return err
+	}
+	err = repo.PushContext(ctx, &gogit.PushOptions{
+		RemoteName: remote,
+		Auth:       auth,
+		RefSpecs:   []config.RefSpec{config.RefSpec(name + ":" + name)},
+	})
+	if err == gogit.NoErrAlreadyUpToDate {
+		return nil
+	}
+	return classify(err)
+}
+
+func headAndRevision(repo *gogit.Repository, revision string) (*object.Commit, *object.Commit, error) {
+	head, err := repo.Head()
+	if err != nil {
+		return nil, nil, err
+	}
+	headCommit, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		return nil, nil, err
+	}
+	hash, err := repo.ResolveRevision(plumbing.Revision(revision))
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", revision, err)
+	}
+	commit, err := repo.CommitObject(*hash)
+	if err != nil {
+		return nil, nil, err
+	}
+	return headCommit, commit, nil
+}
+
+// unsigned fails if the commits should be signed.
+// go-git can't use the gpg agent or SSH keys to sign.
+func unsigned(ctx context.Context) error {
+	if _, ok := git.SigningFromContext(ctx); ok {
+		return fmt.Errorf("signing: %w", ErrUnsupported)
+	}
+	return nil
+}
+
+// signature follows git in preferring the environment to the config.
+func signature(repo *gogit.Repository) (*object.Signature, error) {
+	name := os.Getenv("GIT_AUTHOR_NAME")
+	email := os.Getenv("GIT_AUTHOR_EMAIL")
+	if name == "" || email == "" {
+		cfg, err := repo.ConfigScoped(config.SystemScope)
+		if err != nil {
+			return nil, err
+		}
+		if name == "" {
+			name = cfg.User.Name
+		}
+		if email == "" {
+			email = cfg.User.Email
+		}
+	}
+	if name == "" || email == "" {
+		return nil, gogit.ErrMissingAuthor
+	}
+	return &object.Signature{Name: name, Email: email, When: time.Now()}, nil
+}
@@ -0,0 +1,275 @@
+package gogit
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os/exec"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/git-depend/git-depend/pkg/git"
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+)
+
+func TestNotes(t *testing.T) {
+	url := createRemote(t)
+	cache := createCache(t)
+	ctx := context.Background()
+
+	if err := cache.AddNotes(ctx, url, "test-lock", "first note"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.AddNotes(ctx, url, "test-lock", "again"); err == nil {
+		t.Fatal("Should not be able to add a second note to HEAD.")
+	}
+	if err := cache.AppendNotes(ctx, url, "test-lock", "second note"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.PushNotes(ctx, url, "test-lock"); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := cache.ShowNotes(ctx, url, "test-lock", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "first note\n\nsecond note\n" {
+		t.Fatalf("Unexpected note: %q", out)
+	}
+
+	// The git binary must be able to read the notes.
+	head := gitOutput(t, repoPath(url), "rev-parse", "HEAD")
+	if got := gitOutput(t, repoPath(url), "notes", "--ref", "test-lock", "show", head); got != "first note\n\nsecond note" {
+		t.Fatalf("git notes show: %q", got)
+	}
+	list, err := cache.ListNotes(ctx, url, "test-lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := gitOutput(t, repoPath(url), "notes", "--ref", "test-lock", "list"); strings.TrimSpace(string(list)) != got {
+		t.Fatalf("Expected %q, got %q", got, list)
+	}
+
+	if err := cache.RemoveNotes(ctx, url, "test-lock", head); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.PushNotes(ctx, url, "test-lock"); err != nil {
+		t.Fatal(err)
+	}
+	if got := gitOutput(t, repoPath(url), "notes", "--ref", "test-lock", "list"); got != "" {
+		t.Fatalf("Note was not removed: %q", got)
+	}
+}
+
+func TestFetchGitNotes(t *testing.T) {
+	url := createRemote(t)
+	cache := createCache(t)
+	ctx := context.Background()
+
+	if _, err := cache.CloneOrUpdate(ctx, url); err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, repoPath(url), "notes", "--ref", "git-depend-deps", "add", "-m", "from git")
+
+	if err := cache.FetchNotes(ctx, url, "git-depend-deps"); err != nil {
+		t.Fatal(err)
+	}
+	out, err := cache.ShowNotes(ctx, url, "git-depend-deps", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "from git\n" {
+		t.Fatalf("Unexpected note: %q", out)
+	}
+
+	// A remote without notes is not an error.
+	if err := cache.FetchNotes(ctx, url, "missing"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPushNotesRejected(t *testing.T) {
+	url := createRemote(t)
+	ctx := context.Background()
+
+	other := createCache(t)
+	if err := other.AddNotes(ctx, url, "test-lock", "first note"); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.PushNotes(ctx, url, "test-lock"); err != nil {
+		t.Fatal(err)
+	}
+
+	cache := createCache(t)
+	if _, err := cache.CloneOrUpdate(ctx, url); err != nil {
+		t.Fatal(err)
+	}
+	other.FetchNotes(ctx, url, "test-lock")
+	if err := other.AppendNotes(ctx, url, "test-lock", "other"); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.PushNotes(ctx, url, "test-lock"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cache.AppendNotes(ctx, url, "test-lock", "second note"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.PushNotes(ctx, url, "test-lock"); !errors.Is(err, git.ErrNonFastForward) {
+		t.Fatal("Should not be able to push notes: ", err)
+	}
+
+	// The default strategy takes their note.
+	if err := cache.FetchNotes(ctx, url, "test-lock"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.PushNotes(ctx, url, "test-lock"); err != nil {
+		t.Fatal(err)
+	}
+	head := gitOutput(t, repoPath(url), "rev-parse", "HEAD")
+	if got := gitOutput(t, repoPath(url), "notes", "--ref", "test-lock", "show", head); got != "first note\n\nother" {
+		t.Fatalf("Unexpected note: %q", got)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	work := repoPath(createRemote(t))
+	runGit(t, work, "checkout", "-q", "-b", "feature")
+	runGit(t, work, "commit", "-q", "--allow-empty", "-m", "Feature.")
+	feature := gitOutput(t, work, "rev-parse", "feature")
+	url := createBareRemote(t, work)
+	dir := repoPath(url)
+
+	cache := createCache(t)
+	if err := cache.Merge(context.Background(), url, "feature", "master", "merged", git.MergeOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if got := gitOutput(t, dir, "log", "-1", "--format=%s", "master"); got != "merged" {
+		t.Fatalf("Expected the marker commit, got %q", got)
+	}
+	if got := gitOutput(t, dir, "rev-parse", "master^"); got != feature {
+		t.Fatalf("master was not fast-forwarded to feature: %s", got)
+	}
+}
+
+func TestMergeNotFastForward(t *testing.T) {
+	work := repoPath(createRemote(t))
+	runGit(t, work, "checkout", "-q", "-b", "feature")
+	runGit(t, work, "commit", "-q", "--allow-empty", "-m", "Feature.")
+	runGit(t, work, "checkout", "-q", "master")
+	runGit(t, work, "commit", "-q", "--allow-empty", "-m", "Master.")
+	url := createBareRemote(t, work)
+	dir := repoPath(url)
+	master := gitOutput(t, dir, "rev-parse", "master")
+
+	cache := createCache(t)
+	if err := cache.Merge(context.Background(), url, "feature", "master", "merged", git.MergeOptions{}); err == nil {
+		t.Fatal("Should not be able to rebase.")
+	}
+	if got := gitOutput(t, dir, "rev-parse", "master"); got != master {
+		t.Fatal("master should not have changed.")
+	}
+}
+
+func TestMergeCommit(t *testing.T) {
+	work := repoPath(createRemote(t))
+	runGit(t, work, "checkout", "-q", "-b", "feature")
+	runGit(t, work, "commit", "-q", "--allow-empty", "-m", "Feature.")
+	feature := gitOutput(t, work, "rev-parse", "feature")
+	master := gitOutput(t, work, "rev-parse", "master")
+	url := createBareRemote(t, work)
+	dir := repoPath(url)
+
+	cache := createCache(t)
+	if err := cache.Merge(context.Background(), url, "feature", "master", "merged", git.MergeOptions{Strategy: git.MergeNoFF}); err != nil {
+		t.Fatal(err)
+	}
+	if got := gitOutput(t, dir, "log", "-1", "--format=%s", "master"); got != "merged" {
+		t.Fatalf("Expected the merge commit, got %q", got)
+	}
+	if got := gitOutput(t, dir, "rev-parse", "master^1", "master^2"); got != master+"\n"+feature {
+		t.Fatalf("Unexpected parents: %s", got)
+	}
+}
+
+func TestAheadBehind(t *testing.T) {
+	work := repoPath(createRemote(t))
+	runGit(t, work, "checkout", "-q", "-b", "feature")
+	runGit(t, work, "commit", "-q", "--allow-empty", "-m", "Feature.")
+	runGit(t, work, "commit", "-q", "--allow-empty", "-m", "More.")
+	runGit(t, work, "checkout", "-q", "master")
+	runGit(t, work, "commit", "-q", "--allow-empty", "-m", "Master.")
+	url := createBareRemote(t, work)
+
+	ahead, behind, err := createCache(t).AheadBehind(context.Background(), url, "feature", "master", git.MergeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ahead != 2 || behind != 1 {
+		t.Fatalf("Expected feature to be 2 ahead and 1 behind: %d %d", ahead, behind)
+	}
+}
+
+func TestHTTPAuth(t *testing.T) {
+	if auth, err := httpAuth("Authorization: Bearer token"); err != nil || auth.(*http.TokenAuth).Token != "token" {
+		t.Fatal("Unexpected bearer auth: ", auth, err)
+	}
+	if auth, err := httpAuth("Authorization: Basic dXNlcjpwYXNz"); err != nil || auth.(*http.BasicAuth).Password != "pass" {
+		t.Fatal("Unexpected basic auth: ", auth, err)
+	}
+	if _, err := httpAuth("X-Token: token"); !errors.Is(err, ErrUnsupported) {
+		t.Fatal("Other headers are not supported: ", err)
+	}
+}
+
+func createCache(t *testing.T) *git.Cache {
+	cache, err := git.NewCacheWithOptions(t.TempDir(), git.CacheOptions{Backend: Backend{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cache
+}
+
+// Creates a git repo with a single commit.
+// Returns file://{dir}
+func createRemote(t *testing.T) string {
+	dir := t.TempDir()
+	runGit(t, dir, "init", "-q")
+	if err := ioutil.WriteFile(path.Join(dir, "emptyFile.txt"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, dir, "add", "-A")
+	runGit(t, dir, "commit", "-q", "-m", "Init.")
+	runGit(t, dir, "branch", "-M", "master")
+	return "file://" + dir
+}
+
+// Creates a bare clone of the work directory, so that branches can be pushed.
+// Returns file://{dir}
+func createBareRemote(t *testing.T, work string) string {
+	dir := t.TempDir()
+	runGit(t, dir, "clone", "-q", "--bare", work, ".")
+	return "file://" + dir
+}
+
+func repoPath(url string) string {
+	return strings.TrimPrefix(url, "file://")
+}
+
+// Runs git in the directory and fails the test on error.
+func runGit(t *testing.T, dir string, args ...string) {
+	gitOutput(t, dir, args...)
+}
+
+func gitOutput(t *testing.T, dir string, args ...string) string {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Log(string(out))
+		t.Fatal("Failed to run git: " + err.Error())
+	}
+	return strings.TrimSpace(string(out))
+}
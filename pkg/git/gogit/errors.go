@@ -0,0 +1,34 @@
+package gogit
+
+import (
+	"errors"
+	"net"
+	"strings"
+
+	"github.com/git-depend/git-depend/pkg/git"
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+// classify a go-git error into the same kinds as the git binary.
+func classify(err error) error {
+	var netErr net.Error
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, transport.ErrAuthenticationRequired),
+		errors.Is(err, transport.ErrAuthorizationFailed),
+		errors.Is(err, transport.ErrInvalidAuthMethod):
+		return git.Classify(git.ErrAuthentication, err)
+	case errors.Is(err, gogit.ErrForceNeeded),
+		errors.Is(err, gogit.ErrNonFastForwardUpdate),
+		strings.Contains(err.Error(), "non-fast-forward"):
+		// The last case is a rejection reported by the remote.
+		return git.Classify(git.ErrNonFastForward, err)
+	case errors.Is(err, gogit.NoMatchingRefSpecError{}):
+		return git.Classify(git.ErrRemoteRefMissing, err)
+	case errors.As(err, &netErr):
+		return git.Classify(git.ErrNetwork, err)
+	}
+	return err
+}
@@ -0,0 +1,444 @@
+package gogit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/git-depend/git-depend/pkg/git"
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// notes is a notes ref, which is a history of trees mapping objects to blobs.
+// Trees are read with or without fanout, and written without.
+type notes struct {
+	repo  *gogit.Repository
+	name  plumbing.ReferenceName
+	tip   plumbing.Hash
+	blobs map[string]plumbing.Hash
+}
+
+func (Backend) FetchNotes(ctx context.Context, directory string, remote string, ref string, strategy git.NotesMergeStrategy) error {
+	repo, err := gogit.PlainOpen(directory)
+	if err != nil {
+		return err
+	}
+	tracking := remoteNotesRef(remote, ref)
+	spec := config.RefSpec("+" + notesRef(ref) + ":" + tracking)
+	if err := fetch(ctx, repo, remote, spec); err != nil {
+		if errors.Is(err, git.ErrRemoteRefMissing) {
+			return nil
+		}
+		return err
+	}
+
+	local, err := readNotes(repo, notesRef(ref))
+	if err != nil {
+		return err
+	}
+	theirs, err := readNotes(repo, tracking)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case local.tip == theirs.tip:
+		return nil
+	case local.tip.IsZero() || isAncestor(repo, local.tip, theirs.tip):
+		// Fast-forward.
+		return repo.Storer.SetReference(plumbing.NewHashReference(local.name, theirs.tip))
+	case isAncestor(repo, theirs.tip, local.tip):
+		// Local notes have not been pushed yet.
+		return nil
+	}
+	if err := local.merge(theirs, strategy); err != nil {
+		return fmt.Errorf("notes %s have diverged from the remote: %w", ref, err)
+	}
+	return nil
+}
+
+func (Backend) ResetNotes(ctx context.Context, directory string, remote string, ref string) error {
+	repo, err := gogit.PlainOpen(directory)
+	if err != nil {
+		return err
+	}
+	tracking, err := repo.Reference(remoteNotesRef(remote, ref), true)
+	if err == plumbing.ErrReferenceNotFound {
+		return repo.Storer.RemoveReference(notesRef(ref))
+	} else if err != nil {
+		return err
+	}
+	return repo.Storer.SetReference(plumbing.NewHashReference(notesRef(ref), tracking.Hash()))
+}
+
+func (Backend) AddNotes(ctx context.Context, directory string, ref string, note string) error {
+	n, object, err := openNotes(directory, ref, "HEAD")
+	if err != nil {
+		return err
+	}
+	if _, ok := n.blobs[object]; ok {
+		return git.Classify(git.ErrNoteExists, fmt.Errorf("cannot add notes for object %s", object))
+	}
+	if err := n.set(object, cleanNote(note)); err != nil {
+		return err
+	}
+	return n.commit("Notes added by 'git notes add'", n.tip)
+}
+
+func (Backend) AppendNotes(ctx context.Context, directory string, ref string, note string) error {
+	n, object, err := openNotes(directory, ref, "HEAD")
+	if err != nil {
+		return err
+	}
+	content := cleanNote(note)
+	if existing, err := n.read(object); err != nil {
+		return err
+	} else if existing != "" {
+		content = existing + "\n" + content
+	}
+	if err := n.set(object, content); err != nil {
+		return err
+	}
+	return n.commit("Notes added by 'git notes append'", n.tip)
+}
+
+func (Backend) SetNotes(ctx context.Context, directory string, ref string, object string, note string) error {
+	n, object, err := openNotes(directory, ref, object)
+	if err != nil {
+		return err
+	}
+	if err := n.set(object, cleanNote(note)); err != nil {
+		return err
+	}
+	return n.commit("Notes added by 'git notes add'", n.tip)
+}
+
+// ListNotes in the same format as git notes list.
+func (Backend) ListNotes(ctx context.Context, directory string, ref string) ([]byte, error) {
+	n, _, err := openNotes(directory, ref, "")
+	if err != nil {
+		return nil, err
+	}
+	objects := make([]string, 0, len(n.blobs))
+	for object := range n.blobs {
+		objects = append(objects, object)
+	}
+	sort.Strings(objects)
+	var list strings.Builder
+	for _, object := range objects {
+		list.WriteString(n.blobs[object].String() + " " + object + "\n")
+	}
+	return []byte(list.String()), nil
+}
+
+// ShowNotes defaults to HEAD if no object is given.
+func (Backend) ShowNotes(ctx context.Context, directory string, ref string, object string) ([]byte, error) {
+	if object == "" {
+		object = "HEAD"
+	}
+	n, object, err := openNotes(directory, ref, object)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := n.blobs[object]; !ok {
+		return nil, git.Classify(git.ErrNoteMissing, fmt.Errorf("no note found for object %s", object))
+	}
+	content, err := n.read(object)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(content), nil
+}
+
+func (Backend) RemoveNotes(ctx context.Context, directory string, ref string, object string) error {
+	n, object, err := openNotes(directory, ref, object)
+	if err != nil {
+		return err
+	}
+	if _, ok := n.blobs[object]; !ok {
+		return git.Classify(git.ErrNoteMissing, fmt.Errorf("object %s has no note", object))
+	}
+	delete(n.blobs, object)
+	return n.commit("Notes removed by 'git notes remove'", n.tip)
+}
+
+func notesRef(ref string) plumbing.ReferenceName {
+	return plumbing.ReferenceName("refs/notes/" + ref)
+}
+
+func remoteNotesRef(remote string, ref string) plumbing.ReferenceName {
+	return plumbing.ReferenceName("refs/notes/remotes/" + remote + "/" + ref)
+}
+
+// openNotes reads the notes ref and resolves the revision to an object.
+func openNotes(directory string, ref string, revision string) (*notes, string, error) {
+	repo, err := gogit.PlainOpen(directory)
+	if err != nil {
+		return nil, "", err
+	}
+	n, err := readNotes(repo, notesRef(ref))
+	if err != nil {
+		return nil, "", err
+	}
+	if revision == "" {
+		return n, "", nil
+	}
+	hash, err := repo.ResolveRevision(plumbing.Revision(revision))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to resolve '%s' as a valid ref: %w", revision, err)
+	}
+	return n, hash.String(), nil
+}
+
+// readNotes returns empty notes if the ref doesn't exist.
+func readNotes(repo *gogit.Repository, name plumbing.ReferenceName) (*notes, error) {
+	n := &notes{repo: repo, name: name, blobs: make(map[string]plumbing.Hash)}
+	ref, err := repo.Reference(name, true)
+	if err == plumbing.ErrReferenceNotFound {
+		return n, nil
+	} else if err != nil {
+		return nil, err
+	}
+	n.tip = ref.Hash()
+	n.blobs, err = readNotesTree(repo, n.tip)
+	if err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+func readNotesTree(repo *gogit.Repository, hash plumbing.Hash) (map[string]plumbing.Hash, error) {
+	blobs := make(map[string]plumbing.Hash)
+	if hash.IsZero() {
+		return blobs, nil
+	}
+	commit, err := repo.CommitObject(hash)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+	err = tree.Files().ForEach(func(f *object.File) error {
+		// Remove the fanout directories.
+		blobs[strings.ReplaceAll(f.Name, "/", "")] = f.Hash
+		return nil
+	})
+	return blobs, err
+}
+
+func (n *notes) read(object string) (string, error) {
+	hash, ok := n.blobs[object]
+	if !ok {
+		return "", nil
+	}
+	blob, err := n.repo.BlobObject(hash)
+	if err != nil {
+		return "", err
+	}
+	r, err := blob.Reader()
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	content, err := ioutil.ReadAll(r)
+	return string(content), err
+}
+
+func (n *notes) set(object string, content string) error {
+	obj := n.repo.Storer.NewEncodedObject()
+	obj.SetType(plumbing.BlobObject)
+	w, err := obj.Writer()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte(content)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	hash, err := n.repo.Storer.SetEncodedObject(obj)
+	if err != nil {
+		return err
+	}
+	n.blobs[object] = hash
+	return nil
+}
+
+// commit the notes on top of the parents and update the ref.
+func (n *notes) commit(message string, parents ...plumbing.Hash) error {
+	objects := make([]string, 0, len(n.blobs))
+	for object := range n.blobs {
+		objects = append(objects, object)
+	}
+	sort.Strings(objects)
+	tree := &object.Tree{}
+	for _, name := range objects {
+		tree.Entries = append(tree.Entries, object.TreeEntry{Name: name, Mode: filemode.Regular, Hash: n.blobs[name]})
+	}
+	treeHash, err := store(n.repo, tree)
+	if err != nil {
+		return err
+	}
+
+	author, err := signature(n.repo)
+	if err != nil {
+		return err
+	}
+	c := &object.Commit{
+		Author:    *author,
+		Committer: *author,
+		Message:   message + "\n",
+		TreeHash:  treeHash,
+	}
+	for _, parent := range parents {
+		if !parent.IsZero() {
+			c.ParentHashes = append(c.ParentHashes, parent)
+		}
+	}
+	hash, err := store(n.repo, c)
+	if err != nil {
+		return err
+	}
+	n.tip = hash
+	return n.repo.Storer.SetReference(plumbing.NewHashReference(n.name, hash))
+}
+
+// merge theirs into the notes with a merge commit.
+// Notes which were changed on both sides are resolved with the strategy.
+func (n *notes) merge(theirs *notes, strategy git.NotesMergeStrategy) error {
+	base, err := mergeBase(n.repo, n.tip, theirs.tip)
+	if err != nil {
+		return err
+	}
+	baseBlobs, err := readNotesTree(n.repo, base)
+	if err != nil {
+		return err
+	}
+
+	objects := make(map[string]bool)
+	for _, blobs := range []map[string]plumbing.Hash{baseBlobs, n.blobs, theirs.blobs} {
+		for object := range blobs {
+			objects[object] = true
+		}
+	}
+	for object := range objects {
+		b, ours, their := baseBlobs[object], n.blobs[object], theirs.blobs[object]
+		switch {
+		case ours == their || their == b:
+			continue
+		case ours == b:
+			n.blobs[object] = their
+			if their.IsZero() {
+				delete(n.blobs, object)
+			}
+			continue
+		}
+
+		// Both sides changed the note.
+		switch strategy {
+		case git.NotesMergeOurs:
+		case git.NotesMergeTheirs:
+			n.blobs[object] = their
+		case git.NotesMergeUnion, git.NotesMergeCatSortUniq:
+			if err := n.combine(theirs, object, strategy); err != nil {
+				return err
+			}
+		default:
+			return git.Classify(git.ErrConflict, fmt.Errorf("automatic notes merge failed for object %s", object))
+		}
+		if n.blobs[object].IsZero() {
+			delete(n.blobs, object)
+		}
+	}
+	return n.commit("Merged notes from "+theirs.name.String(), n.tip, theirs.tip)
+}
+
+// combine both notes for the object.
+// A note which was removed on one side is kept from the other.
+func (n *notes) combine(theirs *notes, object string, strategy git.NotesMergeStrategy) error {
+	ours, err := n.read(object)
+	if err != nil {
+		return err
+	}
+	their, err := theirs.read(object)
+	if err != nil {
+		return err
+	}
+	var content string
+	switch {
+	case ours == "":
+		content = their
+	case their == "":
+		content = ours
+	case strategy == git.NotesMergeUnion:
+		content = ours + "\n" + their
+	default:
+		lines := strings.Split(strings.TrimRight(ours+their, "\n"), "\n")
+		sort.Strings(lines)
+		unique := lines[:0]
+		for i, line := range lines {
+			if i == 0 || line != lines[i-1] {
+				unique = append(unique, line)
+			}
+		}
+		content = strings.Join(unique, "\n") + "\n"
+	}
+	return n.set(object, content)
+}
+
+// cleanNote stores the note in the same way as git notes -m.
+func cleanNote(note string) string {
+	return strings.TrimRight(note, " \t\n") + "\n"
+}
+
+func isAncestor(repo *gogit.Repository, a plumbing.Hash, b plumbing.Hash) bool {
+	ac, err := repo.CommitObject(a)
+	if err != nil {
+		return false
+	}
+	bc, err := repo.CommitObject(b)
+	if err != nil {
+		return false
+	}
+	ok, err := ac.IsAncestor(bc)
+	return ok && err == nil
+}
+
+// mergeBase returns the zero hash if there isn't one.
+func mergeBase(repo *gogit.Repository, a plumbing.Hash, b plumbing.Hash) (plumbing.Hash, error) {
+	ac, err := repo.CommitObject(a)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	bc, err := repo.CommitObject(b)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	bases, err := ac.MergeBase(bc)
+	if err != nil || len(bases) == 0 {
+		return plumbing.ZeroHash, err
+	}
+	return bases[0].Hash, nil
+}
+
+type encoder interface {
+	Encode(plumbing.EncodedObject) error
+}
+
+func store(repo *gogit.Repository, o encoder) (plumbing.Hash, error) {
+	obj := repo.Storer.NewEncodedObject()
+	if err := o.Encode(obj); err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return repo.Storer.SetEncodedObject(obj)
+}
@@ -0,0 +1,42 @@
+package git
+
+import (
+	"bytes"
+	"context"
+	"strconv"
+)
+
+// IsShallow returns true if the repository was cloned with a depth.
+func IsShallow(ctx context.Context, directory string) (bool, error) {
+	args := []string{"rev-parse", "--is-shallow-repository"}
+	out, err := execute(ctx, directory, args)
+	if err != nil {
+		return false, err
+	}
+	return string(bytes.TrimSpace(out)) == "true", nil
+}
+
+// MergeBase returns the best common ancestor of a and b.
+// Returns an error if there is none in the local history.
+func MergeBase(ctx context.Context, directory string, a string, b string) (string, error) {
+	args := []string{"merge-base", a, b}
+	out, err := execute(ctx, directory, args)
+	if err != nil {
+		return "", err
+	}
+	return string(bytes.TrimSpace(out)), nil
+}
+
+// Deepen a shallow repository by another depth commits.
+func Deepen(ctx context.Context, directory string, remote string, depth int) error {
+	args := []string{"fetch", "--deepen=" + strconv.Itoa(depth), remote}
+	_, err := execute(ctx, directory, args)
+	return err
+}
+
+// Unshallow fetches the entire history of a shallow repository.
+func Unshallow(ctx context.Context, directory string, remote string) error {
+	args := []string{"fetch", "--unshallow", remote}
+	_, err := execute(ctx, directory, args)
+	return err
+}
@@ -1,12 +1,167 @@
 package git
 
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// MergeStrategy decides how a branch lands and which commit carries the metadata.
+type MergeStrategy string
+
+const (
+	// MergeRebaseFF rebases the branch, fast-forwards and adds an empty commit with the metadata.
+	MergeRebaseFF MergeStrategy = "rebase-ff"
+	// MergeNoFF always creates a merge commit, whose message is the metadata.
+	MergeNoFF MergeStrategy = "merge-commit"
+	// MergeSquash commits all the changes of the branch as one commit, whose message is the metadata.
+	MergeSquash MergeStrategy = "squash"
+	// MergeFFOnlyNoMarker fast-forwards without any new commit.
+	// The metadata is a note on the new tip in MergeNotesRef.
+	MergeFFOnlyNoMarker MergeStrategy = "ff-only-no-marker"
+)
+
+// DefaultMergeStrategy is used when no strategy is set.
+const DefaultMergeStrategy = MergeRebaseFF
+
+// MergeOptions of Cache.Merge.
+type MergeOptions struct {
+	// Strategy defaults to DefaultMergeStrategy.
+	Strategy MergeStrategy
+	// FromURL is the repository with the branch to merge, e.g. a fork.
+	// Defaults to the upstream repository.
+	FromURL string
+	// Notes are the notes refs whose note on the tip of the branch is copied to the new tip.
+	// If the branch has no note, the note of the old tip of the target is kept.
+	Notes []string
+}
+
+// MergeNotesRef holds the metadata of merges which don't add a commit.
+const MergeNotesRef = "git-depend-merge"
+
+// Valid returns an error if the strategy is not known.
+// The empty strategy is valid and means the default.
+func (strategy MergeStrategy) Valid() error {
+	switch strategy {
+	case "", MergeRebaseFF, MergeNoFF, MergeSquash, MergeFFOnlyNoMarker:
+		return nil
+	}
+	return fmt.Errorf("unknown merge strategy %q", string(strategy))
+}
+
 // Merge will use --ff-only.
-func Merge(directory string, branch string) error {
+func Merge(ctx context.Context, directory string, branch string) error {
 	args := []string{
 		"merge",
 		"--ff-only",
 		branch,
 	}
-	_, err := execute(directory, args)
+	_, err := execute(ctx, directory, args)
 	return err
 }
+
+// MergeCommit merges the branch with a merge commit, even if it could fast-forward.
+// A conflicted merge is aborted.
+func MergeCommit(ctx context.Context, directory string, branch string, message string) error {
+	args := []string{
+		"merge",
+		"--no-ff",
+		"-m",
+		message,
+		branch,
+	}
+	if _, err := execute(ctx, directory, args); err != nil {
+		execute(ctx, directory, []string{"merge", "--abort"})
+		return err
+	}
+	return nil
+}
+
+// SquashMerge commits the changes of the branch as a single commit.
+// A conflicted merge is thrown away.
+func SquashMerge(ctx context.Context, directory string, branch string, message string) error {
+	args := []string{
+		"merge",
+		"--squash",
+		branch,
+	}
+	if _, err := execute(ctx, directory, args); err != nil {
+		execute(ctx, directory, []string{"reset", "--hard", "HEAD"})
+		return err
+	}
+	return EmptyCommit(ctx, directory, message)
+}
+
+// mergeTreeVersion is the first version of git with merge-tree --write-tree.
+var mergeTreeVersion = [2]int{2, 38}
+
+// requireMergeTree returns an error if the git binary is too old for merge-tree --write-tree.
+func requireMergeTree() error {
+	version, err := gitVersion()
+	if err != nil {
+		return err
+	}
+	return checkMergeTree(version)
+}
+
+// checkMergeTree of the output of git version, e.g. "git version 2.39.5".
+func checkMergeTree(version string) error {
+	return checkVersion(version, mergeTreeVersion, "can't check whether branches merge")
+}
+
+// CanMerge returns the files which conflict when from is merged into to.
+// No files means that it merges cleanly.
+// Neither the worktree nor the index are touched, so it is safe while another merge runs.
+// Requires git 2.38 for merge-tree --write-tree.
+func CanMerge(ctx context.Context, directory string, from string, to string) ([]string, error) {
+	if err := requireMergeTree(); err != nil {
+		return nil, err
+	}
+	args := []string{
+		"merge-tree",
+		"--write-tree",
+		"--name-only",
+		"--no-messages",
+		to,
+		from,
+	}
+	_, err := execute(ctx, directory, args)
+	if err == nil {
+		return nil, nil
+	}
+	// A conflict exits with 1 after writing the tree.
+	// A bad revision also exits with 1, but writes nothing.
+	var exitErr *ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode != 1 || len(exitErr.Stdout) == 0 {
+		return nil, err
+	}
+	// The first line is the tree which was written.
+	lines := strings.Split(strings.TrimSpace(string(exitErr.Stdout)), "\n")
+	return lines[1:], nil
+}
+
+// AheadBehind returns the number of commits on from which are not on to, and the other way around.
+func AheadBehind(ctx context.Context, directory string, from string, to string) (int, int, error) {
+	args := []string{
+		"rev-list",
+		"--left-right",
+		"--count",
+		from + "..." + to,
+	}
+	out, err := execute(ctx, directory, args)
+	if err != nil {
+		return 0, 0, err
+	}
+	counts := strings.Fields(string(out))
+	if len(counts) != 2 {
+		return 0, 0, fmt.Errorf("unexpected output of rev-list: %q", out)
+	}
+	ahead, err := strconv.Atoi(counts[0])
+	if err != nil {
+		return 0, 0, err
+	}
+	behind, err := strconv.Atoi(counts[1])
+	return ahead, behind, err
+}
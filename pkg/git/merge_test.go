@@ -0,0 +1,329 @@
+package git
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestMergeStrategies(t *testing.T) {
+	tests := []struct {
+		strategy MergeStrategy
+		check    func(t *testing.T, dir string, master string, feature string)
+	}{
+		{MergeRebaseFF, func(t *testing.T, dir string, master string, feature string) {
+			if got := gitOutput(t, dir, "log", "-1", "--format=%s", "master"); got != "merged" {
+				t.Fatalf("Expected the marker commit, got %q", got)
+			}
+			if got := gitOutput(t, dir, "rev-parse", "master^"); got != feature {
+				t.Fatal("master was not fast-forwarded to feature.")
+			}
+		}},
+		{MergeNoFF, func(t *testing.T, dir string, master string, feature string) {
+			if got := gitOutput(t, dir, "log", "-1", "--format=%s", "master"); got != "merged" {
+				t.Fatalf("Expected the merge commit, got %q", got)
+			}
+			if got := gitOutput(t, dir, "rev-parse", "master^1", "master^2"); got != master+"\n"+feature {
+				t.Fatalf("Unexpected parents: %s", got)
+			}
+		}},
+		{MergeSquash, func(t *testing.T, dir string, master string, feature string) {
+			if got := gitOutput(t, dir, "log", "-1", "--format=%s", "master"); got != "merged" {
+				t.Fatalf("Expected the squash commit, got %q", got)
+			}
+			if got := gitOutput(t, dir, "rev-list", "--parents", "-1", "master"); len(strings.Fields(got)) != 2 || strings.Fields(got)[1] != master {
+				t.Fatalf("Unexpected parents: %s", got)
+			}
+			gitOutput(t, dir, "cat-file", "-e", "master:feature.txt")
+		}},
+		{MergeFFOnlyNoMarker, func(t *testing.T, dir string, master string, feature string) {
+			if got := gitOutput(t, dir, "rev-parse", "master"); got != feature {
+				t.Fatal("master was not fast-forwarded to feature.")
+			}
+			if got := gitOutput(t, dir, "notes", "--ref", MergeNotesRef, "show", "master"); got != "merged" {
+				t.Fatalf("Expected the metadata in a note, got %q", got)
+			}
+		}},
+	}
+	for _, test := range tests {
+		t.Run(string(test.strategy), func(t *testing.T) {
+			url, master, feature := createBareRemoteWithFeature(t)
+			cache := createLocalGitCache(t)
+			if err := cache.Merge(context.Background(), url, "feature", "master", "merged", MergeOptions{Strategy: test.strategy}); err != nil {
+				t.Fatal(err)
+			}
+			test.check(t, strings.TrimPrefix(url, "file://"), master, feature)
+		})
+	}
+}
+
+func TestMergeCopiesNotes(t *testing.T) {
+	for _, strategy := range []MergeStrategy{MergeRebaseFF, MergeNoFF, MergeSquash, MergeFFOnlyNoMarker} {
+		t.Run(string(strategy), func(t *testing.T) {
+			url, _, _ := createBareRemoteWithFeature(t)
+			dir := strings.TrimPrefix(url, "file://")
+			runGit(t, dir, "notes", "--ref", "git-depend-test", "add", "-m", "deps", "feature")
+
+			cache := createLocalGitCache(t)
+			options := MergeOptions{Strategy: strategy, Notes: []string{"git-depend-test", "git-depend-missing"}}
+			if err := cache.Merge(context.Background(), url, "feature", "master", "merged", options); err != nil {
+				t.Fatal(err)
+			}
+			if got := gitOutput(t, dir, "notes", "--ref", "git-depend-test", "show", "master"); got != "deps" {
+				t.Fatalf("Expected the note of feature on master, got %q", got)
+			}
+		})
+	}
+}
+
+func TestMergeAfterFailedPush(t *testing.T) {
+	url, _, feature := createBareRemoteWithFeature(t)
+	dir := strings.TrimPrefix(url, "file://")
+	// The hook rejects the first push only.
+	hook := path.Join(dir, "hooks", "pre-receive")
+	if err := ioutil.WriteFile(hook, []byte("#!/bin/sh\nrm \"$0\"\nexit 1\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	cache := createLocalGitCache(t)
+	if err := cache.Merge(context.Background(), url, "feature", "master", "merged", MergeOptions{}); err == nil {
+		t.Fatal("The push should have been rejected.")
+	}
+	if err := cache.Merge(context.Background(), url, "feature", "master", "merged", MergeOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if got := gitOutput(t, dir, "log", "--format=%s", feature+"..master"); got != "merged" {
+		t.Fatalf("Expected exactly one marker commit, got %q", got)
+	}
+}
+
+func TestAheadBehind(t *testing.T) {
+	url, _, _ := createBareRemoteWithFeature(t)
+	cache := createLocalGitCache(t)
+	ctx := context.Background()
+
+	ahead, behind, err := cache.AheadBehind(ctx, url, "feature", "master", MergeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ahead != 1 || behind != 0 {
+		t.Fatalf("Expected feature to be 1 ahead and 0 behind: %d %d", ahead, behind)
+	}
+	ahead, behind, err = cache.AheadBehind(ctx, url, "master", "feature", MergeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ahead != 0 || behind != 1 {
+		t.Fatalf("Expected master to be 0 ahead and 1 behind: %d %d", ahead, behind)
+	}
+	if _, _, err := cache.AheadBehind(ctx, url, "missing", "master", MergeOptions{}); !errors.Is(err, ErrRemoteRefMissing) {
+		t.Fatal("Expected the branch to be missing: ", err)
+	}
+}
+
+func TestMergeUnknownStrategy(t *testing.T) {
+	url, master, _ := createBareRemoteWithFeature(t)
+	cache := createLocalGitCache(t)
+	if err := cache.Merge(context.Background(), url, "feature", "master", "merged", MergeOptions{Strategy: "octopus"}); err == nil {
+		t.Fatal("Should not merge with an unknown strategy.")
+	}
+	if got := gitOutput(t, strings.TrimPrefix(url, "file://"), "rev-parse", "master"); got != master {
+		t.Fatal("master should not have changed.")
+	}
+}
+
+func TestMergeCommitConflict(t *testing.T) {
+	url, master, _ := createBareRemoteWithFeature(t)
+	dir := strings.TrimPrefix(url, "file://")
+
+	// Change the same file on master.
+	work := t.TempDir()
+	runGit(t, work, "clone", "-q", url, ".")
+	if err := ioutil.WriteFile(path.Join(work, "feature.txt"), []byte("master\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, work, "add", "-A")
+	runGit(t, work, "commit", "-q", "-m", "Master.")
+	runGit(t, work, "push", "-q", "origin", "master")
+	master = gitOutput(t, dir, "rev-parse", "master")
+
+	cache := createLocalGitCache(t)
+	for _, strategy := range []MergeStrategy{MergeNoFF, MergeSquash} {
+		err := cache.Merge(context.Background(), url, "feature", "master", "merged", MergeOptions{Strategy: strategy})
+		if !errors.Is(err, ErrConflict) {
+			t.Fatalf("%s: expected a conflict: %v", strategy, err)
+		}
+	}
+	if got := gitOutput(t, dir, "rev-parse", "master"); got != master {
+		t.Fatal("master should not have changed.")
+	}
+}
+
+func TestMergeRebaseConflict(t *testing.T) {
+	url, master, feature := createBareRemoteWithFeature(t)
+	dir := strings.TrimPrefix(url, "file://")
+
+	work := t.TempDir()
+	runGit(t, work, "clone", "-q", url, ".")
+	// Only the middle commit of the branch conflicts with master.
+	runGit(t, work, "checkout", "-q", "-b", "conflict", feature)
+	for i, file := range []string{"one.txt", "master.txt", "three.txt"} {
+		if err := ioutil.WriteFile(path.Join(work, file), []byte(file+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		runGit(t, work, "add", "-A")
+		runGit(t, work, "commit", "-q", "-m", fmt.Sprintf("Commit %d.", i))
+	}
+	runGit(t, work, "checkout", "-q", "master")
+	if err := ioutil.WriteFile(path.Join(work, "master.txt"), []byte("master\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, work, "add", "-A")
+	runGit(t, work, "commit", "-q", "-m", "Master.")
+	runGit(t, work, "push", "-q", "origin", "master", "conflict")
+	master = gitOutput(t, dir, "rev-parse", "master")
+
+	cache := createLocalGitCache(t)
+	ctx := context.Background()
+	err := cache.Merge(ctx, url, "conflict", "master", "merged", MergeOptions{Strategy: MergeRebaseFF})
+	if !errors.Is(err, ErrConflict) {
+		t.Fatal("Expected a conflict: ", err)
+	}
+	if got := gitOutput(t, dir, "rev-parse", "master"); got != master {
+		t.Fatal("master should not have changed.")
+	}
+	sha, err := cache.CloneOrUpdate(ctx, url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path.Join(cache.root, sha, ".git", "rebase-merge")); !os.IsNotExist(err) {
+		t.Fatal("The rebase should have been aborted.")
+	}
+
+	if err := cache.Merge(ctx, url, "feature", "master", "merged", MergeOptions{Strategy: MergeRebaseFF}); err != nil {
+		t.Fatal("The clone should merge again: ", err)
+	}
+}
+
+// Creates a bare remote with a feature branch which adds feature.txt.
+// Returns the URL and the SHAs of master and feature.
+func createBareRemoteWithFeature(t *testing.T) (string, string, string) {
+	work := strings.TrimPrefix(createLocalGitRepo(t), "file://")
+	runGit(t, work, "branch", "-M", "master")
+	runGit(t, work, "checkout", "-q", "-b", "feature")
+	if err := ioutil.WriteFile(path.Join(work, "feature.txt"), []byte("feature\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, work, "add", "-A")
+	runGit(t, work, "commit", "-q", "-m", "Feature.")
+
+	dir := t.TempDir()
+	runGit(t, dir, "clone", "-q", "--bare", work, ".")
+	runGit(t, dir, "symbolic-ref", "HEAD", "refs/heads/master")
+	return "file://" + dir, gitOutput(t, dir, "rev-parse", "master"), gitOutput(t, dir, "rev-parse", "feature")
+}
+
+// Runs git in the directory and returns the trimmed stdout.
+func gitOutput(t *testing.T, dir string, args ...string) string {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("Failed to run git %v: %v", args, err)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func TestMergeFromFork(t *testing.T) {
+	url, master, _ := createBareRemoteWithFeature(t)
+	dir := strings.TrimPrefix(url, "file://")
+	fork := t.TempDir()
+	runGit(t, fork, "clone", "-q", "--bare", url, ".")
+	// Only the fork has the branch.
+	runGit(t, fork, "branch", "forked", "feature")
+	forkURL := "file://" + fork
+
+	ctx := context.Background()
+	cache, err := NewCacheWithOptions(t.TempDir(), CacheOptions{Remotes: map[string]string{forkURL: "alice"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	options := MergeOptions{FromURL: forkURL}
+	if files, err := cache.CanMerge(ctx, url, "forked", "master", options); err != nil || len(files) != 0 {
+		t.Fatal("forked should merge cleanly: ", files, err)
+	}
+	if err := cache.Merge(ctx, url, "forked", "master", "merged", options); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := gitOutput(t, dir, "log", "-1", "--format=%s", "master"); got != "merged" {
+		t.Fatalf("Expected the marker commit upstream, got %q", got)
+	}
+	gitOutput(t, dir, "cat-file", "-e", "master:feature.txt")
+	if got := gitOutput(t, fork, "rev-parse", "master"); got != master {
+		t.Fatal("Nothing should be pushed to the fork.")
+	}
+	clone, err := cache.GetRepositoryDirectory(ctx, url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := gitOutput(t, clone, "remote", "get-url", "alice"); got != forkURL {
+		t.Fatalf("Expected the fork to be the alice remote: %s", got)
+	}
+}
+
+func TestCheckMergeTree(t *testing.T) {
+	for _, test := range []struct {
+		version string
+		ok      bool
+	}{
+		{"git version 2.39.5\n", true},
+		{"git version 2.38.0", true},
+		{"git version 2.37.3", false},
+		{"git version 1.8.3.1", false},
+	} {
+		if err := checkMergeTree(test.version); (err == nil) != test.ok {
+			t.Errorf("%q: unexpected result %v", test.version, err)
+		}
+	}
+}
+
+func TestCanMerge(t *testing.T) {
+	url, _, _ := createBareRemoteWithFeature(t)
+	cache := createLocalGitCache(t)
+	ctx := context.Background()
+	if files, err := cache.CanMerge(ctx, url, "feature", "master", MergeOptions{}); err != nil || len(files) != 0 {
+		t.Fatal("feature should merge cleanly: ", files, err)
+	}
+
+	// Change the same file on master.
+	work := t.TempDir()
+	runGit(t, work, "clone", "-q", url, ".")
+	if err := ioutil.WriteFile(path.Join(work, "feature.txt"), []byte("master\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, work, "add", "-A")
+	runGit(t, work, "commit", "-q", "-m", "Master.")
+	runGit(t, work, "push", "-q", "origin", "master")
+
+	if _, err := cache.CloneOrUpdate(ctx, url); err != nil {
+		t.Fatal(err)
+	}
+	files, err := cache.CanMerge(ctx, url, "feature", "master", MergeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0] != "feature.txt" {
+		t.Fatalf("Expected feature.txt to conflict: %v", files)
+	}
+
+	if _, err := cache.CanMerge(ctx, url, "missing", "master", MergeOptions{}); err == nil {
+		t.Fatal("Should fail for a missing branch.")
+	}
+}
@@ -1,23 +1,25 @@
 package git
 
+import "context"
+
 // PushNotes will push the requested note to the remote.
-func PushNotes(remote string, directory string, ref string) error {
+func PushNotes(ctx context.Context, remote string, directory string, ref string) error {
 	args := []string{
 		"push",
 		remote,
 		"refs/notes/" + ref,
 	}
-	_, err := execute(directory, args)
+	_, err := execute(ctx, directory, args)
 	return err
 }
 
 // Push a repository to remote/branch.
-func Push(remote string, directory string, branch string) error {
+func Push(ctx context.Context, remote string, directory string, branch string) error {
 	args := []string{
 		"push",
 		remote,
 		branch,
 	}
-	_, err := execute(directory, args)
+	_, err := execute(ctx, directory, args)
 	return err
 }
@@ -1,10 +1,17 @@
 package git
 
-func Rebase(directory string, remote string) error {
+import "context"
+
+// Rebase the current branch onto the remote branch.
+// A conflicted rebase is aborted, so that the clone can be used again.
+func Rebase(ctx context.Context, directory string, remote string) error {
 	args := []string{
 		"rebase",
 		remote,
 	}
-	_, err := execute(directory, args)
-	return err
+	if _, err := execute(ctx, directory, args); err != nil {
+		execute(ctx, directory, []string{"rebase", "--abort"})
+		return err
+	}
+	return nil
 }
@@ -0,0 +1,204 @@
+package git
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// Signing formats, the same as gpg.format.
+const (
+	SigningOpenPGP = "openpgp"
+	SigningSSH     = "ssh"
+)
+
+// Signing configures the signatures on the commits which are made,
+// including the commits of the notes refs.
+type Signing struct {
+	// Format is SigningOpenPGP or SigningSSH, defaults to SigningOpenPGP.
+	Format string
+	// Key is the GPG key ID, or the path of the SSH key.
+	// Defaults to the user.signingKey of the git configuration.
+	Key string
+	// AllowedSigners is the file of trusted SSH keys, used to verify SSH signatures.
+	AllowedSigners string
+}
+
+// Enabled returns true if commits should be signed.
+func (signing Signing) Enabled() bool {
+	return signing.Format != "" || signing.Key != ""
+}
+
+type signingKey struct{}
+
+// WithSigning returns a context which signs every commit made by git.
+func WithSigning(ctx context.Context, signing Signing) context.Context {
+	return context.WithValue(ctx, signingKey{}, signing)
+}
+
+// SigningFromContext returns the signing configuration of the context, if there is any.
+func SigningFromContext(ctx context.Context) (Signing, bool) {
+	signing, ok := ctx.Value(signingKey{}).(Signing)
+	return signing, ok
+}
+
+// config returns the git configuration for signing.
+func (signing Signing) config() [][2]string {
+	format := signing.Format
+	if format == "" {
+		format = SigningOpenPGP
+	}
+	config := [][2]string{
+		{"commit.gpgSign", "true"},
+		{"gpg.format", format},
+	}
+	if signing.Key != "" {
+		config = append(config, [2]string{"user.signingKey", signing.Key})
+	}
+	if signing.AllowedSigners != "" {
+		config = append(config, [2]string{"gpg.ssh.allowedSignersFile", signing.AllowedSigners})
+	}
+	return config
+}
+
+// SignNotes makes the commit at the tip of the notes ref again with the same tree and parents, as git notes can't sign it.
+// It is signed if the context has signing, and the transaction of the context is added to its message,
+// so that the commit can be found when the transaction is verified.
+// A commit which came from the remote, or which needs neither, is left alone.
+func SignNotes(ctx context.Context, directory string, remote string, ref string) error {
+	name := "refs/notes/" + ref
+	tip, err := resolveRef(ctx, directory, name)
+	if err != nil || tip == "" {
+		return err
+	}
+	if remoteTip, err := resolveRef(ctx, directory, remoteNotesRef(remote, ref)); err != nil || remoteTip == tip {
+		return err
+	}
+
+	out, err := execute(ctx, directory, []string{"cat-file", "commit", tip})
+	if err != nil {
+		return err
+	}
+	commit := strings.SplitN(string(out), "\n\n", 2)
+	var message string
+	if len(commit) == 2 {
+		message = strings.TrimSuffix(commit[1], "\n")
+	}
+	_, sign := SigningFromContext(ctx)
+	var trailer string
+	if transaction := Transaction(ctx); transaction != "" && !strings.Contains(message, "Transaction: "+transaction) {
+		trailer = "Transaction: " + transaction
+	}
+
+	var args []string
+	var tree string
+	signed := false
+	for _, line := range strings.Split(commit[0], "\n") {
+		switch {
+		case strings.HasPrefix(line, "gpgsig"):
+			signed = true
+		case strings.HasPrefix(line, "tree "):
+			tree = strings.TrimPrefix(line, "tree ")
+		case strings.HasPrefix(line, "parent "):
+			args = append(args, "-p", strings.TrimPrefix(line, "parent "))
+		}
+	}
+	if (!sign || signed) && trailer == "" {
+		return nil
+	}
+	if sign {
+		args = append([]string{"-S"}, args...)
+	}
+	if trailer != "" {
+		message += "\n\n" + trailer
+	}
+	args = append([]string{"commit-tree"}, args...)
+	out, err = execute(ctx, directory, append(args, "-m", message, tree))
+	if err != nil {
+		return err
+	}
+	_, err = execute(ctx, directory, []string{"update-ref", name, strings.TrimSpace(string(out)), tip})
+	return err
+}
+
+// VerifyCommit checks the signature of a commit.
+// Returns an error if it is unsigned or the signature is bad.
+func VerifyCommit(ctx context.Context, directory string, revision string) error {
+	_, err := execute(ctx, directory, []string{"verify-commit", revision})
+	return err
+}
+
+// FindCommits returns the commits reachable from the revision which mention the text.
+// Branches are searched by the commit messages. Notes refs are also searched by their changes,
+// as only the notes commits made again by SignNotes mention a transaction in their messages.
+// Only commits since the time are searched, unless it is zero.
+func FindCommits(ctx context.Context, directory string, revision string, text string, since time.Time) ([]string, error) {
+	searches := []string{"--grep=" + text}
+	if strings.HasPrefix(revision, "refs/notes/") {
+		searches = append(searches, "-S"+text)
+	}
+	var commits []string
+	found := make(map[string]bool)
+	for _, search := range searches {
+		args := []string{
+			"log",
+			"--format=%H",
+			"--fixed-strings",
+			search,
+		}
+		if !since.IsZero() {
+			args = append(args, "--since="+since.Format(time.RFC3339))
+		}
+		out, err := execute(ctx, directory, append(args, revision))
+		if err != nil {
+			return nil, err
+		}
+		for _, commit := range strings.Fields(string(out)) {
+			if !found[commit] {
+				found[commit] = true
+				commits = append(commits, commit)
+			}
+		}
+	}
+	return commits, nil
+}
+
+// withSigning returns a context which signs commits, if signing is configured.
+func (cache *Cache) withSigning(ctx context.Context) context.Context {
+	if !cache.options.Signing.Enabled() {
+		return ctx
+	}
+	return WithSigning(ctx, cache.options.Signing)
+}
+
+// signNotes signs the notes commit which was just made, if signing is configured,
+// and records the transaction of the context in it.
+func (cache *Cache) signNotes(ctx context.Context, directory string, ref string) error {
+	if !cache.options.Signing.Enabled() && Transaction(ctx) == "" {
+		return nil
+	}
+	return cache.backend.SignNotes(cache.withSigning(ctx), directory, upstream, ref)
+}
+
+// VerifyCommit checks the signature of a commit in the repository.
+// Returns an error if it is unsigned or the signature is bad.
+func (cache *Cache) VerifyCommit(ctx context.Context, url string, revision string) error {
+	dir, err := cache.GetRepositoryDirectory(ctx, url)
+	if err != nil {
+		return err
+	}
+	return cache.backend.VerifyCommit(cache.withSigning(ctx), dir, revision)
+}
+
+// FindCommits returns the commits reachable from the ref which mention the text, since the time if it isn't zero.
+// The ref is a full ref name, and there are no commits if it doesn't exist.
+func (cache *Cache) FindCommits(ctx context.Context, url string, ref string, text string, since time.Time) ([]string, error) {
+	dir, err := cache.GetRepositoryDirectory(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	if sha, err := cache.backend.ResolveRef(ctx, dir, ref); err != nil || sha == "" {
+		return nil, err
+	}
+	return cache.backend.FindCommits(ctx, dir, ref, text, since)
+}
@@ -0,0 +1,73 @@
+package git
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/git-depend/git-depend/pkg/internal/testkey"
+)
+
+func TestSignedMerge(t *testing.T) {
+	url, _, _ := createBareRemoteWithFeature(t)
+	ctx := context.Background()
+	cache, err := NewCacheWithOptions(t.TempDir(), CacheOptions{Signing: createSigningKey(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cache.Merge(ctx, url, "feature", "master", "merged", MergeOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.VerifyCommit(ctx, url, "origin/master"); err != nil {
+		t.Fatal("The marker commit should be signed: ", err)
+	}
+	commits, err := cache.FindCommits(ctx, url, "refs/remotes/origin/master", "merged", time.Time{})
+	if err != nil || len(commits) != 1 {
+		t.Fatal("Expected to find the marker commit: ", commits, err)
+	}
+	// Only the messages of branches are searched, not the files.
+	if commits, err := cache.FindCommits(ctx, url, "refs/remotes/origin/master", "feature", time.Time{}); err != nil || len(commits) != 0 {
+		t.Fatal("Should not find the contents of feature.txt: ", commits, err)
+	}
+	if commits, err := cache.FindCommits(ctx, url, "refs/remotes/origin/master", "merged", time.Now().Add(time.Hour)); err != nil || len(commits) != 0 {
+		t.Fatal("Should not find commits before the time: ", commits, err)
+	}
+
+	if err := cache.AddNotes(ctx, url, "git-depend-test", "note"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.VerifyCommit(ctx, url, "refs/notes/git-depend-test"); err != nil {
+		t.Fatal("The notes commit should be signed: ", err)
+	}
+	commits, err = cache.FindCommits(ctx, url, "refs/notes/git-depend-test", "note", time.Time{})
+	if err != nil || len(commits) != 1 {
+		t.Fatal("Expected to find the notes commit: ", commits, err)
+	}
+}
+
+func TestUnsignedNotes(t *testing.T) {
+	url := createLocalGitRepo(t)
+	ctx := context.Background()
+	cache := createLocalGitCache(t)
+	if err := cache.AddNotes(ctx, url, "git-depend-test", "note"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.VerifyCommit(ctx, url, "refs/notes/git-depend-test"); err == nil {
+		t.Fatal("The notes commit should not be signed.")
+	}
+}
+
+// Creates an SSH signing key which is trusted for any principal.
+// Skips the test if ssh-keygen is not installed.
+func createSigningKey(t *testing.T) Signing {
+	key, allowed, err := testkey.New(t.TempDir())
+	if errors.Is(err, exec.ErrNotFound) {
+		t.Skip("ssh-keygen is not installed")
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	return Signing{Format: SigningSSH, Key: key, AllowedSigners: allowed}
+}
@@ -0,0 +1,88 @@
+package git
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// maxTraceOutput is how much of stdout and stderr is kept in a trace entry.
+const maxTraceOutput = 4096
+
+// TraceEntry records a single run of git.
+// The trace is written as one JSON object per line.
+type TraceEntry struct {
+	Time        time.Time `json:"Time"`
+	Transaction string    `json:"Transaction,omitempty"`
+	Directory   string    `json:"Directory"`
+	Args        []string  `json:"Args"`
+	DurationMs  int64     `json:"DurationMs"`
+	// ExitCode is -1 if git didn't exit by itself, e.g. it was killed.
+	ExitCode int    `json:"ExitCode"`
+	Stdout   string `json:"Stdout,omitempty"`
+	Stderr   string `json:"Stderr,omitempty"`
+	Error    string `json:"Error,omitempty"`
+}
+
+type transactionKey struct{}
+
+var traceMutex sync.Mutex
+var traceWriter io.Writer
+
+// SetTrace writes a TraceEntry to w for every git command.
+// A nil writer turns tracing off.
+func SetTrace(w io.Writer) {
+	traceMutex.Lock()
+	defer traceMutex.Unlock()
+	traceWriter = w
+}
+
+// WithTransaction returns a context which tags every git command with the ID.
+func WithTransaction(ctx context.Context, ID string) context.Context {
+	return context.WithValue(ctx, transactionKey{}, ID)
+}
+
+// Transaction returns the ID of the context, or an empty string.
+func Transaction(ctx context.Context) string {
+	ID, _ := ctx.Value(transactionKey{}).(string)
+	return ID
+}
+
+// trace writes the entry if tracing is on.
+// Failing to write the trace doesn't fail the command.
+func trace(ctx context.Context, directory string, args []string, start time.Time, exitCode int, stdout []byte, stderr []byte, err error) {
+	traceMutex.Lock()
+	defer traceMutex.Unlock()
+	if traceWriter == nil {
+		return
+	}
+	entry := TraceEntry{
+		Time:        start,
+		Transaction: Transaction(ctx),
+		Directory:   directory,
+		Args:        args,
+		DurationMs:  time.Since(start).Milliseconds(),
+		ExitCode:    exitCode,
+		Stdout:      trimOutput(stdout),
+		Stderr:      trimOutput(stderr),
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	line, jsonErr := json.Marshal(entry)
+	if jsonErr != nil {
+		return
+	}
+	traceWriter.Write(append(line, '\n'))
+}
+
+func trimOutput(output []byte) string {
+	output = bytes.TrimSpace(output)
+	if len(output) > maxTraceOutput {
+		return string(output[:maxTraceOutput]) + "..."
+	}
+	return string(output)
+}
@@ -0,0 +1,44 @@
+package git
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestTrace(t *testing.T) {
+	var buf bytes.Buffer
+	SetTrace(&buf)
+	defer SetTrace(nil)
+
+	dir := t.TempDir()
+	ctx := WithTransaction(context.Background(), "abc123")
+	if _, err := execute(ctx, dir, []string{"init"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := execute(ctx, dir, []string{"rev-parse", "--verify", "HEAD"}); err == nil {
+		t.Fatal("HEAD should not exist.")
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 entries: %q", buf.String())
+	}
+	var entries [2]TraceEntry
+	for i, line := range lines {
+		if err := json.Unmarshal([]byte(line), &entries[i]); err != nil {
+			t.Fatal(err)
+		}
+		if entries[i].Transaction != "abc123" || entries[i].Directory != dir {
+			t.Fatalf("Unexpected entry: %s", line)
+		}
+	}
+	if entries[0].ExitCode != 0 || entries[0].Args[0] != "init" || entries[0].Stdout == "" {
+		t.Fatalf("Unexpected entry: %s", lines[0])
+	}
+	if entries[1].ExitCode != 128 || entries[1].Stderr == "" || entries[1].Error == "" {
+		t.Fatalf("Unexpected entry: %s", lines[1])
+	}
+}
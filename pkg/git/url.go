@@ -0,0 +1,92 @@
+package git
+
+import (
+	"net/url"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// URLRule rewrites any URL starting with From so that it starts with To.
+// Rules are applied before normalisation, which allows equivalent remotes
+// such as mirrors to share a single identity.
+type URLRule struct {
+	From string
+	To   string
+}
+
+// URLRules is a set of equivalence rules.
+type URLRules []URLRule
+
+// Default ports which are dropped from the canonical form.
+var defaultPorts = map[string]string{
+	"ssh":   "22",
+	"git":   "9418",
+	"http":  "80",
+	"https": "443",
+}
+
+// Matches scp-like syntax, e.g. git@host:org/repo.git
+var scpURL = regexp.MustCompile(`^(?:[^@/]+@)?([^:/]+):(.*)$`)
+
+// NormaliseURL returns the canonical form of a URL with no equivalence rules.
+func NormaliseURL(rawURL string) string {
+	return URLRules(nil).Normalise(rawURL)
+}
+
+// Normalise applies the rules and returns the canonical form of the URL.
+// Network URLs are reduced to host[:port]/path with the scheme, user,
+// default port and trailing .git removed, so that https://host/org/repo
+// and git@host:org/repo.git are equal.
+// Local paths and file:// URLs are only cleaned.
+func (rules URLRules) Normalise(rawURL string) string {
+	return canonicalURL(rules.rewrite(strings.TrimSpace(rawURL)))
+}
+
+// rewrite applies the longest matching rule, in the same way as git's insteadOf.
+func (rules URLRules) rewrite(rawURL string) string {
+	sorted := make(URLRules, len(rules))
+	copy(sorted, rules)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return len(sorted[i].From) > len(sorted[j].From)
+	})
+	for _, rule := range sorted {
+		if rule.From != "" && strings.HasPrefix(rawURL, rule.From) {
+			return rule.To + strings.TrimPrefix(rawURL, rule.From)
+		}
+	}
+	return rawURL
+}
+
+func canonicalURL(rawURL string) string {
+	if strings.Contains(rawURL, "://") {
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			return rawURL
+		}
+		scheme := strings.ToLower(u.Scheme)
+		if scheme == "file" {
+			return "file://" + path.Clean(u.Path)
+		}
+		host := strings.ToLower(u.Hostname())
+		if port := u.Port(); port != "" && port != defaultPorts[scheme] {
+			host += ":" + port
+		}
+		return joinHostPath(host, u.Path)
+	}
+
+	// A single letter before the colon is a Windows drive, not a host.
+	if m := scpURL.FindStringSubmatch(rawURL); m != nil && len(m[1]) > 1 {
+		return joinHostPath(strings.ToLower(m[1]), m[2])
+	}
+
+	return filepath.Clean(rawURL)
+}
+
+func joinHostPath(host string, p string) string {
+	p = strings.Trim(path.Clean("/"+p), "/")
+	p = strings.TrimSuffix(p, ".git")
+	return host + "/" + p
+}
@@ -0,0 +1,42 @@
+package git
+
+import "testing"
+
+func TestNormaliseURL(t *testing.T) {
+	tests := []struct {
+		url      string
+		expected string
+	}{
+		{"https://host/org/repo", "host/org/repo"},
+		{"https://host/org/repo.git", "host/org/repo"},
+		{"https://host/org/repo/", "host/org/repo"},
+		{"git@host:org/repo.git", "host/org/repo"},
+		{"ssh://git@host/org/repo.git", "host/org/repo"},
+		{"ssh://git@host:22/org/repo.git", "host/org/repo"},
+		{"ssh://git@host:2222/org/repo.git", "host:2222/org/repo"},
+		{"HTTPS://Host/org/repo", "host/org/repo"},
+		{"file:///tmp/repo/", "file:///tmp/repo"},
+		{"/tmp/repo/", "/tmp/repo"},
+	}
+	for _, test := range tests {
+		if out := NormaliseURL(test.url); out != test.expected {
+			t.Errorf("%s: expected %s, got %s", test.url, test.expected, out)
+		}
+	}
+}
+
+func TestNormaliseURLRules(t *testing.T) {
+	rules := URLRules{
+		{From: "https://mirror/", To: "https://host/"},
+		{From: "https://mirror/special/", To: "https://other/"},
+	}
+	if out := rules.Normalise("https://mirror/org/repo"); out != "host/org/repo" {
+		t.Fatal("Rule not applied: " + out)
+	}
+	if out := rules.Normalise("https://mirror/special/repo.git"); out != "other/repo" {
+		t.Fatal("Longest rule not applied: " + out)
+	}
+	if out := rules.Normalise("git@host:org/repo"); out != "host/org/repo" {
+		t.Fatal("Unexpected rewrite: " + out)
+	}
+}
@@ -0,0 +1,195 @@
+package git
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+)
+
+// CacheProblem is something wrong with a directory in the cache.
+type CacheProblem string
+
+const (
+	// ProblemCorrupt means git fsck failed or the repository is unreadable.
+	ProblemCorrupt CacheProblem = "corrupt"
+	// ProblemOriginMismatch means origin doesn't hash to the directory name.
+	ProblemOriginMismatch CacheProblem = "origin-mismatch"
+	// ProblemIncomplete means a clone in tmp/ was never renamed.
+	ProblemIncomplete CacheProblem = "incomplete"
+	// ProblemDetached means HEAD is not on a branch.
+	ProblemDetached CacheProblem = "detached"
+	// ProblemDirty means the working tree has changes.
+	ProblemDirty CacheProblem = "dirty"
+)
+
+// VerifyResult for a single directory in the cache.
+type VerifyResult struct {
+	Directory string
+	// URL of origin, if it could be read.
+	URL      string
+	Problems []CacheProblem
+	Repaired bool
+	// Err is set if the repair failed.
+	Err error
+}
+
+// Verify checks the integrity of every repository in the cache.
+// If repair is true, incomplete clones and repositories with an unknown origin are removed,
+// and any other broken repository is cloned again.
+func (cache *Cache) Verify(ctx context.Context, repair bool) ([]*VerifyResult, error) {
+	var results []*VerifyResult
+
+	tmp := path.Join(cache.root, "tmp")
+	entries, err := ioutil.ReadDir(tmp)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, entry := range entries {
+		result := &VerifyResult{
+			Directory: path.Join(tmp, entry.Name()),
+			Problems:  []CacheProblem{ProblemIncomplete},
+		}
+		if repair {
+			result.Err = os.RemoveAll(result.Directory)
+			result.Repaired = result.Err == nil
+		}
+		results = append(results, result)
+	}
+
+	entries, err = ioutil.ReadDir(cache.root)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || entry.Name() == "tmp" {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return results, err
+		}
+		result := cache.verifyRepository(ctx, entry.Name())
+		if len(result.Problems) == 0 {
+			continue
+		}
+		if repair {
+			result.Err = cache.repairRepository(ctx, result)
+			result.Repaired = result.Err == nil
+		}
+		results = append(results, result)
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Directory < results[j].Directory
+	})
+	return results, nil
+}
+
+// RepositoryState of a clone, as found by Backend.Inspect.
+type RepositoryState struct {
+	// Origin is the URL of origin.
+	Origin string
+	// Corrupt is set if the objects or the working tree can't be read.
+	Corrupt bool
+	// Detached is set if HEAD is not on a branch.
+	Detached bool
+	// Dirty is set if the working tree has changes.
+	Dirty bool
+}
+
+// InspectRepository with git config, fsck, symbolic-ref and status.
+func InspectRepository(ctx context.Context, directory string) (RepositoryState, error) {
+	var state RepositoryState
+	out, err := execute(ctx, directory, []string{"config", "--get", "remote.origin.url"})
+	if err != nil {
+		return state, err
+	}
+	state.Origin = string(bytes.TrimSpace(out))
+
+	if _, err := execute(ctx, directory, []string{"fsck", "--no-progress"}); err != nil {
+		state.Corrupt = true
+		return state, nil
+	}
+
+	if _, err := execute(ctx, directory, []string{"symbolic-ref", "-q", "HEAD"}); err != nil {
+		state.Detached = true
+	}
+
+	out, err = execute(ctx, directory, []string{"status", "--porcelain"})
+	if err != nil {
+		state.Corrupt = true
+	} else if len(bytes.TrimSpace(out)) > 0 {
+		state.Dirty = true
+	}
+	return state, nil
+}
+
+func (cache *Cache) verifyRepository(ctx context.Context, name string) *VerifyResult {
+	dir := path.Join(cache.root, name)
+	result := &VerifyResult{Directory: dir}
+
+	state, err := cache.backend.Inspect(ctx, dir)
+	if err != nil {
+		result.Problems = append(result.Problems, ProblemCorrupt)
+		return result
+	}
+	result.URL = state.Origin
+
+	h := cache.getNewHasher()
+	h.Write([]byte(cache.NormaliseURL(result.URL)))
+	if hex.EncodeToString(h.Sum(nil)) != name {
+		result.Problems = append(result.Problems, ProblemOriginMismatch)
+	}
+
+	if state.Corrupt {
+		result.Problems = append(result.Problems, ProblemCorrupt)
+		return result
+	}
+	if state.Detached {
+		result.Problems = append(result.Problems, ProblemDetached)
+	}
+	if state.Dirty {
+		result.Problems = append(result.Problems, ProblemDirty)
+	}
+	return result
+}
+
+// repairRepository removes the directory if we don't know where it came from.
+// Otherwise it clones into tmp/ and swaps the directories.
+func (cache *Cache) repairRepository(ctx context.Context, result *VerifyResult) error {
+	for _, problem := range result.Problems {
+		if problem == ProblemOriginMismatch {
+			result.URL = ""
+		}
+	}
+	if result.URL == "" {
+		return os.RemoveAll(result.Directory)
+	}
+
+	name := path.Base(result.Directory)
+	tmp_directory := path.Join(cache.root, "tmp", name)
+	if err := os.RemoveAll(tmp_directory); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(tmp_directory, 0755); err != nil {
+		return err
+	}
+	strategy := cache.cloneStrategy(cache.NormaliseURL(result.URL))
+	ctx = cache.withCredentials(ctx, result.URL)
+	if err := cache.backend.Clone(ctx, result.URL, tmp_directory, strategy); err != nil {
+		os.RemoveAll(tmp_directory)
+		return err
+	}
+
+	old_directory := tmp_directory + ".old"
+	if err := os.Rename(result.Directory, old_directory); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp_directory, result.Directory); err != nil {
+		return err
+	}
+	return os.RemoveAll(old_directory)
+}
@@ -0,0 +1,105 @@
+package git
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	urls := []string{createLocalGitRepo(t), createLocalGitRepo(t), createLocalGitRepo(t)}
+	cache := createLocalGitCache(t)
+	ctx := context.Background()
+
+	results, err := cache.CloneOrUpdateMany(ctx, urls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if problems, err := cache.Verify(ctx, false); err != nil || len(problems) != 0 {
+		t.Fatal("Expected a clean cache: ", problems, err)
+	}
+
+	// Dirty working tree.
+	file, err := os.Create(path.Join(results[0].Directory, "dirty.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	// Detached HEAD.
+	runGit(t, results[1].Directory, "checkout", "--detach")
+	// Origin no longer matches the key.
+	runGit(t, results[2].Directory, "remote", "set-url", "origin", urls[0])
+	// Half-renamed clone.
+	if err := os.MkdirAll(path.Join(cache.root, "tmp", "abc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	problems, err := cache.Verify(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]CacheProblem{
+		results[0].Directory:                ProblemDirty,
+		results[1].Directory:                ProblemDetached,
+		results[2].Directory:                ProblemOriginMismatch,
+		path.Join(cache.root, "tmp", "abc"): ProblemIncomplete,
+	}
+	if len(problems) != len(expected) {
+		t.Fatal("Incorrect number of problems: ", len(problems))
+	}
+	for _, problem := range problems {
+		if len(problem.Problems) != 1 || problem.Problems[0] != expected[problem.Directory] {
+			t.Fatalf("Unexpected problems for %s: %v", problem.Directory, problem.Problems)
+		}
+	}
+
+	problems, err = cache.Verify(ctx, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, problem := range problems {
+		if !problem.Repaired {
+			t.Fatalf("Not repaired %s: %v", problem.Directory, problem.Err)
+		}
+	}
+	if problems, err := cache.Verify(ctx, false); err != nil || len(problems) != 0 {
+		t.Fatal("Expected the cache to be repaired: ", problems, err)
+	}
+	if _, err := os.Stat(results[2].Directory); !os.IsNotExist(err) {
+		t.Fatal("Expected the mismatched repository to be removed.")
+	}
+}
+
+func TestVerifyBackend(t *testing.T) {
+	fake := NewFakeBackend()
+	urls := []string{"https://host/one.git", "https://host/two.git"}
+	for _, url := range urls {
+		fake.AddRemote(url)
+	}
+	cache, err := NewCacheWithOptions(t.TempDir(), CacheOptions{Backend: fake})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if _, err := cache.CloneOrUpdateMany(ctx, urls); err != nil {
+		t.Fatal(err)
+	}
+	// The fake clones aren't git repositories, so running git would find them corrupt.
+	if problems, err := cache.Verify(ctx, false); err != nil || len(problems) != 0 {
+		t.Fatal("Expected a clean cache: ", problems, err)
+	}
+
+	fake.FailOn("Inspect", urls[1], errors.New("corrupt"))
+	problems, err := cache.Verify(ctx, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 1 || problems[0].Problems[0] != ProblemCorrupt || !problems[0].Repaired {
+		t.Fatalf("Expected the corrupt clone to be repaired: %+v", problems)
+	}
+	if problems, err := cache.Verify(ctx, false); err != nil || len(problems) != 0 {
+		t.Fatal("Expected the cache to be repaired: ", problems, err)
+	}
+}
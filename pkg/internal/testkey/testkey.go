@@ -0,0 +1,31 @@
+// Package testkey creates signing keys for the tests.
+package testkey
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"path"
+)
+
+// New creates an SSH signing key in the directory, which is trusted for any principal.
+// Returns the key and the allowed signers file, for git.Signing with git.SigningSSH.
+// Returns an error wrapping exec.ErrNotFound if ssh-keygen is not installed.
+func New(dir string) (string, string, error) {
+	if _, err := exec.LookPath("ssh-keygen"); err != nil {
+		return "", "", err
+	}
+	key := path.Join(dir, "key")
+	if out, err := exec.Command("ssh-keygen", "-q", "-t", "ed25519", "-N", "", "-f", key).CombinedOutput(); err != nil {
+		return "", "", fmt.Errorf("ssh-keygen: %w: %s", err, out)
+	}
+	public, err := ioutil.ReadFile(key + ".pub")
+	if err != nil {
+		return "", "", err
+	}
+	allowed := path.Join(dir, "allowed_signers")
+	if err := ioutil.WriteFile(allowed, append([]byte("* "), public...), 0644); err != nil {
+		return "", "", err
+	}
+	return key, allowed, nil
+}